@@ -48,16 +48,48 @@ var (
 
 	// maxImageSize for uploads in bytes.
 	maxImageSize int64
+
+	// templatesDir is an optional directory of additional templates, which is
+	// watched for changes. Empty if only the embedded templates are used.
+	templatesDir string
+
+	// templatesReloadInterval between two checks of templatesDir for changes.
+	templatesReloadInterval time.Duration
+
+	// batchParallelism limits the concurrently created sharepics of a batch.
+	batchParallelism int
+
+	// batchMaxRows limits the amount of sharepics within one batch.
+	batchMaxRows int
+
+	// batchMaxSize limits a batch request's body in bytes.
+	batchMaxSize int64
+
+	// fontDirs are searched for font files to look up the characters covered
+	// by a font, used for fallback fonts.
+	fontDirs []string
 )
 
 //go:embed inc/backend.yml
 var backendConfig []byte
 
 // InitEnvironmentConfig initializes configurations from inc/backend.yml.
+//
+// The templates directory might be overwritten by the SHAREPIC_TEMPLATES_DIR
+// environment variable.
 func InitConfig() {
 	type cfg struct {
 		AllowedMimes []string `yaml:"allowed_mimes"`
 		MaxImgSize   int64    `yaml:"max_img_size"`
+
+		TemplatesDir            string        `yaml:"templates_dir"`
+		TemplatesReloadInterval time.Duration `yaml:"templates_reload_interval"`
+
+		BatchParallelism int   `yaml:"batch_parallelism"`
+		BatchMaxRows     int   `yaml:"batch_max_rows"`
+		BatchMaxSize     int64 `yaml:"batch_max_size"`
+
+		FontDirs []string `yaml:"font_dirs"`
 	}
 	var c cfg
 
@@ -75,6 +107,43 @@ func InitConfig() {
 	// maxImageSize
 	maxImageSize = c.MaxImgSize
 	log.Printf("set maximum image size to %dB", maxImageSize)
+
+	// templatesDir
+	templatesDir = c.TemplatesDir
+	if envDir, ok := os.LookupEnv("SHAREPIC_TEMPLATES_DIR"); ok {
+		templatesDir = envDir
+	}
+	if templatesDir != "" {
+		log.Printf("set templates directory to %q", templatesDir)
+	}
+
+	// templatesReloadInterval
+	templatesReloadInterval = c.TemplatesReloadInterval
+	if templatesReloadInterval <= 0 {
+		templatesReloadInterval = 5 * time.Second
+	}
+
+	// batchParallelism
+	batchParallelism = c.BatchParallelism
+	if batchParallelism <= 0 {
+		batchParallelism = 1
+	}
+	log.Printf("set batch parallelism to %d", batchParallelism)
+
+	// batchMaxRows
+	batchMaxRows = c.BatchMaxRows
+	log.Printf("set maximum batch rows to %d", batchMaxRows)
+
+	// batchMaxSize
+	batchMaxSize = c.BatchMaxSize
+	if batchMaxSize <= 0 {
+		batchMaxSize = maxImageSize
+	}
+	log.Printf("set maximum batch size to %dB", batchMaxSize)
+
+	// fontDirs
+	fontDirs = c.FontDirs
+	log.Printf("set font directories to %q", fontDirs)
 }
 
 // IsTestRun if the tool was called with "--test-run".
@@ -96,5 +165,14 @@ func main() {
 		return
 	}
 
+	if Subcommand() != "" {
+		if err := RunSubcommand(); err != nil {
+			log.Printf("%s failed, %v", Subcommand(), err)
+			imagick.Terminate()
+			os.Exit(1)
+		}
+		return
+	}
+
 	LaunchWebserver()
 }
@@ -0,0 +1,274 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the batch mode, rendering multiple sharepics described by
+// the rows of a CSV or JSON manifest.
+//
+// For usage, parse a manifest by parseBatchManifest and pass its rows to
+// RenderBatch, which writes each sharepic once created. The results might be
+// written by writeBatchReport.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// batchRow is one sharepic to be rendered within a batch.
+//
+// The names of the fields are identical to the form fields of the sharepic
+// handler, both for the CSV header and the JSON keys.
+type batchRow struct {
+	Template   string `json:"template"`
+	Message    string `json:"message"`
+	AuthorName string `json:"authorName"`
+	AuthorDesc string `json:"authorDesc"`
+
+	OutputFormat string `json:"outputFormat"`
+	Layout       string `json:"layout"`
+	Language     string `json:"language"`
+
+	// Fields are the custom fields, being columns prefixed by fieldPrefix
+	// within a CSV header, e.g., "field.talkTitle".
+	Fields map[string]string `json:"fields"`
+
+	// Image references the input image, e.g., a file name.
+	Image string `json:"image"`
+
+	// Images reference the input images of image slots, being columns
+	// prefixed by batchImageSlotPrefix within a CSV header, e.g., "image.logo".
+	Images map[string]string `json:"images"`
+}
+
+// batchImageSlotPrefix of image slots within a CSV header.
+const batchImageSlotPrefix = "image."
+
+// batchResult of a single batchRow, either naming the written sharepics, one
+// for each requested layout, or containing an error.
+type batchResult struct {
+	Row     batchRow
+	Outputs []string
+	Error   string
+}
+
+// parseBatchManifest reads batch rows either from a JSON array or a CSV file
+// with a header line. The format is detected by the first character.
+func parseBatchManifest(r io.Reader) ([]batchRow, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read manifest, %w", err)
+	}
+
+	var rows []batchRow
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		decoder := json.NewDecoder(bytes.NewReader(trimmed))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&rows); err != nil {
+			return nil, fmt.Errorf("cannot decode JSON manifest, %w", err)
+		}
+	} else {
+		rows, err = parseBatchManifestCSV(data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("manifest contains no rows")
+	}
+	if len(rows) > batchMaxRows {
+		return nil, fmt.Errorf("manifest's %d rows exceed maximum %d", len(rows), batchMaxRows)
+	}
+
+	return rows, nil
+}
+
+// parseBatchManifestCSV reads batch rows from CSV data with a header line.
+func parseBatchManifestCSV(data []byte) ([]batchRow, error) {
+	records, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("cannot decode CSV manifest, %w", err)
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("CSV manifest misses its header")
+	}
+
+	var rows []batchRow
+	header := records[0]
+	for _, record := range records[1:] {
+		var row batchRow
+		fields := map[string]*string{
+			"template":   &row.Template,
+			"message":    &row.Message,
+			"authorName": &row.AuthorName,
+			"authorDesc": &row.AuthorDesc,
+
+			"outputFormat": &row.OutputFormat,
+			"layout":       &row.Layout,
+			"language":     &row.Language,
+
+			"image": &row.Image,
+		}
+		for i, column := range header {
+			column = strings.TrimSpace(column)
+			if name := strings.TrimPrefix(column, fieldPrefix); name != column {
+				if row.Fields == nil {
+					row.Fields = make(map[string]string)
+				}
+				row.Fields[name] = record[i]
+				continue
+			}
+			if name := strings.TrimPrefix(column, batchImageSlotPrefix); name != column {
+				if row.Images == nil {
+					row.Images = make(map[string]string)
+				}
+				row.Images[name] = strings.TrimSpace(record[i])
+				continue
+			}
+
+			field, ok := fields[column]
+			if !ok {
+				return nil, fmt.Errorf("unknown CSV column %q", column)
+			}
+			*field = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// customization of a row, falling back to the same defaults as the handler.
+func (row batchRow) customization() sharepicCustomization {
+	fallback := func(value, fallback string) string {
+		if value = cleanInputString(value); value == "" {
+			return fallback
+		}
+		return value
+	}
+
+	customFields := make(map[string]string, len(row.Fields))
+	for name, value := range row.Fields {
+		customFields[name] = cleanInputText(value)
+	}
+
+	return sharepicCustomization{
+		Name:    fallback(row.Template, "ilovefs"),
+		Message: cleanInputText(row.Message),
+		Format:  fallback(row.OutputFormat, ""),
+		Layout:  fallback(row.Layout, ""),
+
+		Language: fallback(row.Language, ""),
+
+		AuthorName: fallback(row.AuthorName, "Jane Doe"),
+		AuthorDesc: fallback(row.AuthorDesc, ""),
+
+		Fields: customFields,
+	}
+}
+
+// RenderBatch creates the sharepics for all rows with at most batchParallelism
+// concurrent generations. Images are requested by their reference through
+// readImage. Each sharepic is passed to writeOutput as soon as it is created,
+// one at a time, and is not kept afterwards. The results are in the same order
+// as the rows.
+func RenderBatch(ctx context.Context, rows []batchRow, readImage func(ref string) ([]byte, error), writeOutput func(name string, data []byte) error) []batchResult {
+	results := make([]batchResult, len(rows))
+	semaphore := make(chan struct{}, batchParallelism)
+
+	var writeMutex sync.Mutex
+
+	var wg sync.WaitGroup
+	wg.Add(len(rows))
+
+	for i, row := range rows {
+		go func(i int, row batchRow) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			input := row.customization()
+			results[i] = batchResult{Row: row}
+
+			if err := ctx.Err(); err != nil {
+				results[i].Error = err.Error()
+				return
+			}
+
+			// Rows without an image are left to the template, e.g., with an
+			// optional picture.
+			var imgData []byte
+			var err error
+			if row.Image != "" {
+				if imgData, err = readImage(row.Image); err != nil {
+					results[i].Error = fmt.Sprintf("cannot read image %q, %v", row.Image, err)
+					return
+				}
+			}
+
+			images := inputImages{Picture: imgData, Slots: make(map[string][]byte, len(row.Images))}
+			for name, ref := range row.Images {
+				// Empty references are skipped, e.g., for a batch of multiple templates.
+				if ref == "" {
+					continue
+				}
+				if images.Slots[name], err = readImage(ref); err != nil {
+					results[i].Error = fmt.Sprintf("cannot read image %q of slot %q, %v", ref, name, err)
+					return
+				}
+			}
+
+			sharepics, err := MakeSharepics(ctx, input, images)
+			if err != nil {
+				results[i].Error = fmt.Sprintf("cannot create sharepic, %v", err)
+				return
+			}
+
+			writeMutex.Lock()
+			defer writeMutex.Unlock()
+			for _, sharepic := range sharepics {
+				output := fmt.Sprintf("%03d_%s_%s.%s", i+1, input.Name, sharepic.Layout, sharepic.Format.Extension)
+				if err := writeOutput(output, sharepic.Data); err != nil {
+					results[i].Error = fmt.Sprintf("cannot write sharepic, %v", err)
+					return
+				}
+				results[i].Outputs = append(results[i].Outputs, output)
+			}
+		}(i, row)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// writeBatchReport as CSV, listing each row's output files, separated by
+// spaces, or error.
+func writeBatchReport(w io.Writer, results []batchResult) error {
+	csvWriter := csv.NewWriter(w)
+
+	if err := csvWriter.Write([]string{"row", "template", "image", "output", "status", "error"}); err != nil {
+		return err
+	}
+	for i, result := range results {
+		status := "success"
+		if result.Error != "" {
+			status = "error"
+		}
+
+		record := []string{fmt.Sprint(i + 1), result.Row.Template, result.Row.Image, strings.Join(result.Outputs, " "), status, result.Error}
+		if err := csvWriter.Write(record); err != nil {
+			return err
+		}
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the batch mode's manifests and reports.
+
+package main
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseBatchManifest(t *testing.T) {
+	defer func(maxRows int) { batchMaxRows = maxRows }(batchMaxRows)
+	batchMaxRows = 2
+
+	tests := []struct {
+		name     string
+		manifest string
+		want     []batchRow
+		wantErr  bool
+	}{
+		{
+			name:     "CSV",
+			manifest: "template,message,image\nilovefs,Hello,a.jpg\nsfscon,\"Hello, world\",b.png\n",
+			want: []batchRow{
+				{Template: "ilovefs", Message: "Hello", Image: "a.jpg"},
+				{Template: "sfscon", Message: "Hello, world", Image: "b.png"},
+			},
+		},
+		{
+			name:     "CSV with all columns",
+			manifest: "template,message,authorName,authorDesc,outputFormat,layout,language,image\nilovefs,Hi,Jane,FSFE,png,story,de,a.jpg\n",
+			want: []batchRow{{
+				Template: "ilovefs", Message: "Hi", AuthorName: "Jane", AuthorDesc: "FSFE",
+				OutputFormat: "png", Layout: "story", Language: "de", Image: "a.jpg",
+			}},
+		},
+		{
+			name:     "CSV with prefixed columns",
+			manifest: "template, field.talkTitle ,image.logo\nsfscon, Free Software , logo.png \n",
+			want: []batchRow{{
+				Template: "sfscon",
+				Fields:   map[string]string{"talkTitle": " Free Software "},
+				Images:   map[string]string{"logo": "logo.png"},
+			}},
+		},
+		{
+			name:     "CSV with multiline message",
+			manifest: "template,message\nilovefs,\"First\nSecond\"\n",
+			want:     []batchRow{{Template: "ilovefs", Message: "First\nSecond"}},
+		},
+		{
+			name:     "CSV with missing column",
+			manifest: "template,message\nilovefs\n",
+			wantErr:  true,
+		},
+		{
+			name:     "CSV with additional column",
+			manifest: "template,message\nilovefs,Hello,a.jpg\n",
+			wantErr:  true,
+		},
+		{
+			name:     "CSV with unknown column",
+			manifest: "template,mesage\nilovefs,Hello\n",
+			wantErr:  true,
+		},
+		{
+			name:     "CSV with invalid quoting",
+			manifest: "template,message\nilovefs,\"Hello\n",
+			wantErr:  true,
+		},
+		{
+			name:     "CSV header only",
+			manifest: "template,message\n",
+			wantErr:  true,
+		},
+		{
+			name:     "CSV with too many rows",
+			manifest: "template\na\nb\nc\n",
+			wantErr:  true,
+		},
+		{
+			name:     "empty",
+			manifest: "",
+			wantErr:  true,
+		},
+		{
+			name:     "JSON",
+			manifest: ` [{"template": "ilovefs", "message": "Hello", "image": "a.jpg"}]`,
+			want:     []batchRow{{Template: "ilovefs", Message: "Hello", Image: "a.jpg"}},
+		},
+		{
+			name:     "JSON with fields and images",
+			manifest: `[{"template": "sfscon", "fields": {"talkTitle": "Free Software"}, "images": {"logo": "logo.png"}}]`,
+			want: []batchRow{{
+				Template: "sfscon",
+				Fields:   map[string]string{"talkTitle": "Free Software"},
+				Images:   map[string]string{"logo": "logo.png"},
+			}},
+		},
+		{
+			name:     "JSON with unknown key",
+			manifest: `[{"template": "ilovefs", "mesage": "Hello"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "JSON with CSV column name",
+			manifest: `[{"template": "sfscon", "field.talkTitle": "Free Software"}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "JSON with wrong type",
+			manifest: `[{"template": "ilovefs", "fields": ["Free Software"]}]`,
+			wantErr:  true,
+		},
+		{
+			name:     "JSON object",
+			manifest: `{"template": "ilovefs"}`,
+			wantErr:  true,
+		},
+		{
+			name:     "JSON without rows",
+			manifest: `[]`,
+			wantErr:  true,
+		},
+		{
+			name:     "JSON with too many rows",
+			manifest: `[{"template": "a"}, {"template": "b"}, {"template": "c"}]`,
+			wantErr:  true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, err := parseBatchManifest(strings.NewReader(test.manifest))
+			if (err != nil) != test.wantErr {
+				t.Fatalf("parseBatchManifest(%q) = %v, want error: %t", test.manifest, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(rows, test.want) {
+				t.Errorf("parseBatchManifest(%q) = %+v, want %+v", test.manifest, rows, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteBatchReport(t *testing.T) {
+	results := []batchResult{
+		{Row: batchRow{Template: "ilovefs", Image: "a.jpg"}, Outputs: []string{"001_ilovefs.jpg"}},
+		{Row: batchRow{Template: "sfscon"}, Outputs: []string{"002_sfscon_a.png", "002_sfscon_b.png"}},
+		{Row: batchRow{Template: "unknown"}, Error: "unknown template, \"unknown\""},
+	}
+
+	var buf bytes.Buffer
+	if err := writeBatchReport(&buf, results); err != nil {
+		t.Fatalf("writeBatchReport = %v", err)
+	}
+
+	want := "row,template,image,output,status,error\n" +
+		"1,ilovefs,a.jpg,001_ilovefs.jpg,success,\n" +
+		"2,sfscon,,002_sfscon_a.png 002_sfscon_b.png,success,\n" +
+		"3,unknown,,,error,\"unknown template, \"\"unknown\"\"\"\n"
+	if buf.String() != want {
+		t.Errorf("writeBatchReport = %q, want %q", buf.String(), want)
+	}
+}
@@ -0,0 +1,242 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains subcommands to use the sharepic generation from the
+// command line, without starting the web server.
+//
+// For usage, the RunSubcommand function dispatches the program's arguments.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+)
+
+// subcommands maps a subcommand's name to its implementation, which receives
+// the remaining arguments.
+var subcommands = map[string]func(ctx context.Context, args []string) error{
+	"render": renderCommand,
+	"batch":  batchCommand,
+}
+
+// Subcommand returns the name of the requested subcommand or an empty string.
+func Subcommand() string {
+	if len(os.Args[1:]) == 0 {
+		return ""
+	}
+	if _, ok := subcommands[os.Args[1]]; !ok {
+		return ""
+	}
+	return os.Args[1]
+}
+
+// RunSubcommand executes the subcommand as returned by Subcommand.
+func RunSubcommand() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	return subcommands[os.Args[1]](ctx, os.Args[2:])
+}
+
+// readInputImage from a file and checks its MIME type.
+func readInputImage(input string) ([]byte, error) {
+	imgData, err := os.ReadFile(input)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read image, %w", err)
+	}
+
+	mimeType := detectMime(imgData)
+	if _, ok := allowedMimeTypes[mimeType]; !ok {
+		return nil, fmt.Errorf("unsupported MIME type %q of %s", mimeType, input)
+	}
+
+	return imgData, nil
+}
+
+// fieldsFlag collects custom fields from repeated name=value flags.
+type fieldsFlag map[string]string
+
+func (fields fieldsFlag) String() string {
+	pairs := make([]string, 0, len(fields))
+	for name, value := range fields {
+		pairs = append(pairs, name+"="+value)
+	}
+	return strings.Join(pairs, ", ")
+}
+
+func (fields fieldsFlag) Set(pair string) error {
+	name, value, ok := strings.Cut(pair, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, not %q", pair)
+	}
+	fields[name] = cleanInputText(value)
+	return nil
+}
+
+// imageSlotsFlag collects the paths of image slots' images from repeated
+// name=path flags.
+type imageSlotsFlag map[string]string
+
+func (slots imageSlotsFlag) String() string {
+	return fieldsFlag(slots).String()
+}
+
+func (slots imageSlotsFlag) Set(pair string) error {
+	name, path, ok := strings.Cut(pair, "=")
+	if !ok || name == "" || path == "" {
+		return fmt.Errorf("expected name=path, not %q", pair)
+	}
+	slots[name] = path
+	return nil
+}
+
+// renderCommand creates one sharepic based on the command line arguments.
+func renderCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("render", flag.ContinueOnError)
+
+	template := flags.String("template", "ilovefs", "name of the template")
+	message := flags.String("message", "", "message to be shown, newlines are kept as line breaks")
+	authorName := flags.String("author-name", "Jane Doe", "author's name")
+	authorDesc := flags.String("author-desc", "", "author's description")
+	image := flags.String("image", "", "path to the input image, might be omitted for a template with an optional picture")
+	output := flags.String("output", "", "path to the created sharepic (required)")
+	format := flags.String("format", "", "output format, defaults to the output's file extension or the template's format")
+	layout := flags.String("layout", "", "layout of the template, or all to create every layout next to the output")
+	focusX := flags.Float64("focus-x", defaultPictureCrop.FocusX, "horizontal focal point of the image, from 0 to 1")
+	focusY := flags.Float64("focus-y", defaultPictureCrop.FocusY, "vertical focal point of the image, from 0 to 1")
+	zoom := flags.Float64("zoom", defaultPictureCrop.Zoom, "zoom factor into the image, at least 1")
+	language := flags.String("language", "", "language of the message to hyphenate too wide words, defaults to the template's language")
+	fields := make(fieldsFlag)
+	flags.Var(fields, "field", "custom field of the template as name=value, might be repeated")
+	slots := make(imageSlotsFlag)
+	flags.Var(slots, "image-slot", "path to an image slot's input image as name=path, might be repeated")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *output == "" {
+		flags.Usage()
+		return fmt.Errorf("-output is required")
+	}
+
+	if outputFormat, ok := outputFormatByExtension(filepath.Ext(*output)); ok && *format == "" {
+		*format = outputFormat.Name
+	}
+
+	var imgData []byte
+	var err error
+	if *image != "" {
+		if imgData, err = readInputImage(*image); err != nil {
+			return err
+		}
+	}
+
+	slotsData := make(map[string][]byte, len(slots))
+	for name, path := range slots {
+		if slotsData[name], err = readInputImage(path); err != nil {
+			return fmt.Errorf("image slot %q, %w", name, err)
+		}
+	}
+
+	sharepics, err := MakeSharepics(ctx, sharepicCustomization{
+		Name:    *template,
+		Message: cleanInputText(*message),
+		Format:  *format,
+		Layout:  *layout,
+		Crop:    pictureCrop{FocusX: *focusX, FocusY: *focusY, Zoom: *zoom},
+
+		Language: *language,
+
+		AuthorName: cleanInputString(*authorName),
+		AuthorDesc: cleanInputString(*authorDesc),
+
+		Fields: fields,
+	}, inputImages{Picture: imgData, Slots: slotsData})
+	if err != nil {
+		return fmt.Errorf("cannot create sharepic, %w", err)
+	}
+
+	if *layout != allLayouts {
+		return os.WriteFile(*output, sharepics[0].Data, 0644)
+	}
+
+	// Name all layouts' files after the output, e.g., out_story.jpg.
+	outputBase := strings.TrimSuffix(*output, filepath.Ext(*output))
+	for _, sharepic := range sharepics {
+		layoutOutput := fmt.Sprintf("%s_%s.%s", outputBase, sharepic.Layout, sharepic.Format.Extension)
+		if err := os.WriteFile(layoutOutput, sharepic.Data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// batchCommand creates all sharepics of a manifest into an output directory,
+// next to a report.csv file.
+func batchCommand(ctx context.Context, args []string) error {
+	flags := flag.NewFlagSet("batch", flag.ContinueOnError)
+
+	manifest := flags.String("manifest", "", "path to the CSV or JSON manifest (required)")
+	images := flags.String("images", "", "directory of the referenced images, defaults to the manifest's directory")
+	outputDir := flags.String("output-dir", "", "directory for the created sharepics and the report (required)")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *manifest == "" || *outputDir == "" {
+		flags.Usage()
+		return fmt.Errorf("both -manifest and -output-dir are required")
+	}
+	if *images == "" {
+		*images = filepath.Dir(*manifest)
+	}
+
+	manifestFile, err := os.Open(*manifest)
+	if err != nil {
+		return fmt.Errorf("cannot open manifest, %w", err)
+	}
+	rows, err := parseBatchManifest(manifestFile)
+	_ = manifestFile.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(*outputDir, 0755); err != nil {
+		return fmt.Errorf("cannot create output directory, %w", err)
+	}
+
+	results := RenderBatch(ctx, rows, func(ref string) ([]byte, error) {
+		return readInputImage(filepath.Join(*images, ref))
+	}, func(name string, data []byte) error {
+		return os.WriteFile(filepath.Join(*outputDir, name), data, 0644)
+	})
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+
+	report, err := os.Create(filepath.Join(*outputDir, "report.csv"))
+	if err != nil {
+		return fmt.Errorf("cannot create report, %w", err)
+	}
+	defer report.Close()
+
+	if err := writeBatchReport(report, results); err != nil {
+		return fmt.Errorf("cannot write report, %w", err)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d sharepics failed, see report.csv", failed, len(results))
+	}
+	return nil
+}
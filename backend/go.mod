@@ -8,6 +8,9 @@ go 1.19
 
 require (
 	github.com/oxzi/syscallset-go v0.1.5
+	github.com/rivo/uniseg v0.4.7
+	golang.org/x/image v0.13.0
+	golang.org/x/text v0.13.0
 	gopkg.in/gographics/imagick.v3 v3.5.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -3,7 +3,8 @@
 // SPDX-License-Identifier: AGPL-3.0-or-later
 
 // This file contains entry points for the sharepic generation. A bunch of logic
-// is added during compile time via embed and loaded within the init function.
+// is added during compile time via embed and loaded within InitSharepicConfig,
+// next to an optional templates directory.
 //
 // For usage, the MakeSharepic function is the most relevant one.
 
@@ -13,150 +14,670 @@ import (
 	"context"
 	"embed"
 	"fmt"
+	"io/fs"
 	"log"
-	"path/filepath"
-	"strings"
-	"text/template"
+	"math"
+	"os"
+	"sort"
 	"unicode/utf8"
-
-	"gopkg.in/yaml.v3"
 )
 
-// sharepicTemplate to be customized for each sharepic.Will be populated in this
-// file's init function for all SVG templates.
-var sharepicTemplate *template.Template
-
 // sharepicCustomization contains all template fields from the sharepicTemplate
 // and a name to identify the template, without the file extension.
+//
+// Furthermore, an output format, a layout, and a language for hyphenation might
+// be requested, falling back to the template's defaults, as well as the
+// picture's crop.
 type sharepicCustomization struct {
-	Name    string
-	Message string
+	Name     string
+	Message  string
+	Format   string
+	Layout   string
+	Language string
+	Crop     pictureCrop
 
 	ImageData  string
 	AuthorName string
 	AuthorDesc string
+
+	// ImageMime is the MIME type of ImageData, e.g., image/png for a masked
+	// picture box.
+	ImageMime string
+
+	// Images are the prepared images of the template's image slots by their
+	// names, being populated like ImageData, and ImageMimes their MIME types.
+	Images     map[string]string
+	ImageMimes map[string]string
+
+	// Fields are the values of the template's custom fields by their names.
+	Fields map[string]string
+}
+
+// pictureCrop steers which region of the user submitted picture is used.
+//
+// The focal point is normalized, from 0 to 1 for both the left resp. top and
+// the right resp. bottom border. The zoom factor starts at 1 for the biggest
+// possible region. A zero value results in a centered crop without zoom.
+type pictureCrop struct {
+	FocusX float64
+	FocusY float64
+	Zoom   float64
+}
+
+// maxPictureZoom limits the pictureCrop's zoom factor.
+const maxPictureZoom = 4.0
+
+// defaultPictureCrop is a centered crop without zoom.
+var defaultPictureCrop = pictureCrop{FocusX: 0.5, FocusY: 0.5, Zoom: 1}
+
+// clamped returns the pictureCrop with all values within their ranges.
+func (crop pictureCrop) clamped() pictureCrop {
+	if crop == (pictureCrop{}) {
+		return defaultPictureCrop
+	}
+
+	clamp := func(value, min, max float64) float64 {
+		if math.IsNaN(value) {
+			return min
+		}
+		return math.Max(min, math.Min(max, value))
+	}
+	return pictureCrop{
+		FocusX: clamp(crop.FocusX, 0, 1),
+		FocusY: clamp(crop.FocusY, 0, 1),
+		Zoom:   clamp(crop.Zoom, 1, maxPictureZoom),
+	}
+}
+
+// region of a picture with the base dimensions to be cropped for the wished
+// aspect ratio. The region is as close as possible to the focal point, while
+// staying within the picture.
+func (crop pictureCrop) region(baseWidth, baseHeight, wishWidth, wishHeight float64) (width, height uint, x, y int) {
+	crop = crop.clamped()
+
+	// The scale to cover the wished dimensions, increased by the zoom factor.
+	scale := math.Max(wishWidth/baseWidth, wishHeight/baseHeight) * crop.Zoom
+	regionWidth, regionHeight := math.Min(baseWidth, wishWidth/scale), math.Min(baseHeight, wishHeight/scale)
+
+	regionX := math.Max(0, math.Min(baseWidth-regionWidth, crop.FocusX*baseWidth-regionWidth/2))
+	regionY := math.Max(0, math.Min(baseHeight-regionHeight, crop.FocusY*baseHeight-regionHeight/2))
+
+	return uint(math.Round(regionWidth)), uint(math.Round(regionHeight)), int(regionX), int(regionY)
+}
+
+// inputImages are the user submitted images: the picture and one for each of
+// the template's image slots by its name.
+type inputImages struct {
+	Picture []byte
+	Slots   map[string][]byte
 }
 
-// Template name to be used in sharepicTemplate.
-func (cus sharepicCustomization) Template() string {
-	return cus.Name + ".svg"
+// sharepicImage is a created sharepic of a layout, encoded in its output format.
+type sharepicImage struct {
+	Layout string
+	Data   []byte
+	Format outputFormat
 }
 
 // sharepicConf describes the YAML configuration in an identically named .yml
 // file for each .svg file in the templates directory. It contains the further
 // configuration regarding the message overlay.
 type sharepicConf struct {
-	Sharepic struct {
-		Width  int
-		Height int
-	}
+	Sharepic   canvasConf
+	PictureBox pictureBoxConf `yaml:"picture_box"`
 
-	PictureBox struct {
-		Width     int
-		Height    int
-		Grayscale bool
-	} `yaml:"picture_box"`
+	Font fontConf
 
-	Font struct {
-		Name      string
-		Color     string
-		Uppercase bool
-		Sizes     []int
+	Output struct {
+		Format string
 	}
 
+	// Language of the message, enabling the hyphenation of too wide words.
+	Language string
+
 	MaxLength struct {
 		Message     int
 		Author      int
 		Description int
 	} `yaml:"max_length"`
 
-	MessageBox struct {
-		Disable bool
+	MessageBox messageBoxConf `yaml:"message_box"`
+
+	// ImageSlots are further pictures, e.g., a logo, by their names.
+	ImageSlots map[string]pictureBoxConf `yaml:"image_slots"`
 
-		Width  int
-		Height int
+	// Fields are the template's custom fields by their names.
+	Fields map[string]fieldConf
 
-		MarginWidth  int `yaml:"margin_width"`
-		MarginHeight int `yaml:"margin_height"`
-	} `yaml:"message_box"`
+	// TextBoxes are further boxes, each fitting the text of a field like the
+	// message box, identified by their name.
+	TextBoxes map[string]textBoxConf `yaml:"text_boxes"`
+
+	// Layouts are alternative geometries of the same template, e.g., for
+	// different social networks, identified by their name.
+	Layouts map[string]layoutConf
 }
 
-// sharepicConfs maps the name of a template without a file extension to a
-// sharepicConf, able to derive a generator instance.
-var sharepicConfs map[string]sharepicConf
+// fontConf is the font of a text box, e.g., the message.
+type fontConf struct {
+	Name      string
+	Color     string
+	Uppercase bool
+	Sizes     []int
 
-//go:embed inc/templates/*
-var templatesFs embed.FS
+	// Fallbacks are used in order for characters missing in the font.
+	Fallbacks []string
 
-// InitSharepicConfig from the embedded template files.
-func InitSharepicConfig() {
-	// Populate template with all SVG files.
-	var sharepicTemplateErr error
-	sharepicTemplate, sharepicTemplateErr = template.New("svg").ParseFS(templatesFs, "inc/templates/*.svg")
-	if sharepicTemplateErr != nil {
-		log.Fatalf("cannot parse templates, %v", sharepicTemplateErr)
+	// Bold, Italic, and BoldItalic are the fonts for emphasized parts of
+	// the message, while AccentColor colors its accented parts.
+	Bold        string
+	Italic      string
+	BoldItalic  string `yaml:"bold_italic"`
+	AccentColor string `yaml:"accent_color"`
+
+	// SizeRange replaces Sizes to search the biggest fitting size within.
+	SizeRange struct {
+		Min int
+		Max int
+	} `yaml:"size_range"`
+
+	// Outline, Shadow, and Marker are text effects, each enabled by its color.
+	Outline outlineConf
+	Shadow  shadowConf
+	Marker  markerConf
+}
+
+// family of the font for all styles, with its fonts being resolved.
+func (font fontConf) family() fontFamily {
+	return fontFamily{
+		Regular:    font.Name,
+		Bold:       font.Bold,
+		Italic:     font.Italic,
+		BoldItalic: font.BoldItalic,
+		Fallbacks:  font.Fallbacks,
+	}.resolve()
+}
+
+// outlineConf is a stroke around each glyph, drawn outside of the glyph.
+type outlineConf struct {
+	Color string
+	Width float64
+}
+
+// shadowConf is a copy of the text behind it, moved by the offset and blurred
+// by the blur's sigma.
+type shadowConf struct {
+	Color   string
+	OffsetX int `yaml:"offset_x"`
+	OffsetY int `yaml:"offset_y"`
+	Blur    float64
+}
+
+// markerConf is a box behind each line, enlarged by the padding and having
+// rounded corners for a radius.
+type markerConf struct {
+	Color   string
+	Padding int
+	Radius  float64
+}
+
+// canvasConf is the resulting sharepic's size.
+type canvasConf struct {
+	Width  int
+	Height int
+}
+
+// pictureBoxConf is the size of the user submitted picture.
+type pictureBoxConf struct {
+	Width     int
+	Height    int
+	Grayscale bool
+
+	// Crop is the crop mode, see cropModes, defaulting to center.
+	Crop string
+
+	// Fit is either cover, the default, filling the box by cropping the
+	// picture, contain, letterboxing the whole picture on the Background, or
+	// blur, letterboxing it on a blurred and enlarged copy of itself.
+	Fit        string
+	Background fillConf
+
+	// Mask is either circle or rounded, the latter with the corners' Radius,
+	// resulting in a transparent outside.
+	Mask   string
+	Radius float64
+
+	// Disable results in a sharepic without any picture, while an Optional
+	// picture might be omitted. A missing picture is replaced by the
+	// Placeholder, an image file next to the template, or otherwise the Fill.
+	//
+	// These fields describe the picture's source and cannot be overridden by
+	// a layout, see pictureBoxLayoutConf.
+	Disable     bool
+	Optional    bool
+	Placeholder string
+	Fill        fillConf
+
+	// placeholderData is the content of the Placeholder, read while loading.
+	placeholderData []byte
+}
+
+// fillConf is a solid color or, with GradientTo, a vertical gradient.
+type fillConf struct {
+	Color      string
+	GradientTo string `yaml:"gradient_to"`
+}
+
+// cropModes map each crop mode to its focal point, used unless another one is
+// requested.
+var cropModes = map[string]pictureCrop{
+	"":       defaultPictureCrop,
+	"center": defaultPictureCrop,
+	"top":    {FocusX: 0.5, FocusY: 0, Zoom: 1},
+	"bottom": {FocusX: 0.5, FocusY: 1, Zoom: 1},
+	"left":   {FocusX: 0, FocusY: 0.5, Zoom: 1},
+	"right":  {FocusX: 1, FocusY: 0.5, Zoom: 1},
+}
+
+// crop of the picture box for a requested crop, which takes precedence over
+// the crop mode unless it is the default one.
+func (box pictureBoxConf) crop(requested pictureCrop) pictureCrop {
+	if requested.clamped() != defaultPictureCrop {
+		return requested
 	}
+	return cropModes[box.Crop]
+}
 
-	// Create all template configurations from the YAML files.
-	entries, entriesErr := templatesFs.ReadDir("inc/templates")
-	if entriesErr != nil {
-		log.Fatalf("cannot read embedded templates directory, %v", entriesErr)
+// slotNames of the template's image slots in a stable order.
+func (conf sharepicConf) slotNames() []string {
+	names := make([]string, 0, len(conf.ImageSlots))
+	for name := range conf.ImageSlots {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
+}
+
+// messageBoxConf is the position and size of the message overlay.
+type messageBoxConf struct {
+	Disable bool
 
-	sharepicConfs = make(map[string]sharepicConf)
+	Width  int
+	Height int
+
+	MarginWidth  int `yaml:"margin_width"`
+	MarginHeight int `yaml:"margin_height"`
+
+	// Align is either left, center, or right, and VerticalAlign is either top,
+	// middle, or bottom. Both default to the former.
+	Align         string
+	VerticalAlign string `yaml:"vertical_align"`
+
+	// LineBreaking is either greedy, the default, or balanced.
+	LineBreaking string `yaml:"line_breaking"`
+}
+
+// textBoxConf is a box for the text of a field, see textFields, with its own
+// font. The box's geometry is inlined, while its Disable flag hides the box,
+// e.g., for a layout.
+type textBoxConf struct {
+	Field string
+	Font  fontConf
+	Box   messageBoxConf `yaml:",inline"`
+}
+
+// textFields are the names of the fields a textBoxConf might show next to the
+// template's custom fields, as used by the HTTP form.
+var textFields = []string{"message", "authorName", "authorDesc"}
+
+// textField returns the text of a field named as in textFields or of a custom
+// field.
+func (input sharepicCustomization) textField(name string) (string, bool) {
+	switch name {
+	case "message":
+		return input.Message, true
+	case "authorName":
+		return input.AuthorName, true
+	case "authorDesc":
+		return input.AuthorDesc, true
+	default:
+		text, ok := input.Fields[name]
+		return text, ok
+	}
+}
+
+// textBoxNames of the template in a stable order.
+func (conf sharepicConf) textBoxNames() []string {
+	names := make([]string, 0, len(conf.TextBoxes))
+	for name := range conf.TextBoxes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// layoutConf overrides the geometry of a sharepicConf. Only the set keys are
+// overridden, while each unset part is inherited from the template, as is the
+// SVG template if Svg is empty.
+type layoutConf struct {
+	// Svg names an alternative SVG template without its file extension.
+	Svg string
+
+	Sharepic   canvasLayoutConf
+	PictureBox pictureBoxLayoutConf `yaml:"picture_box"`
+	MessageBox boxLayoutConf        `yaml:"message_box"`
+
+	// TextBoxes and ImageSlots override the geometry of the named boxes.
+	TextBoxes  map[string]boxLayoutConf        `yaml:"text_boxes"`
+	ImageSlots map[string]pictureBoxLayoutConf `yaml:"image_slots"`
+}
+
+// canvasLayoutConf overrides the set keys of a canvasConf.
+type canvasLayoutConf struct {
+	Width  *int
+	Height *int
+}
 
-	for _, entry := range entries {
-		if !strings.HasSuffix(entry.Name(), ".yml") {
-			continue
+// pictureBoxLayoutConf overrides the set keys of a pictureBoxConf's geometry,
+// lacking its source fields.
+type pictureBoxLayoutConf struct {
+	Width      *int
+	Height     *int
+	Grayscale  *bool
+	Crop       *string
+	Fit        *string
+	Background fillLayoutConf
+	Mask       *string
+	Radius     *float64
+}
+
+// fillLayoutConf overrides the set keys of a fillConf.
+type fillLayoutConf struct {
+	Color      *string
+	GradientTo *string `yaml:"gradient_to"`
+}
+
+// boxLayoutConf overrides the set keys of a messageBoxConf.
+type boxLayoutConf struct {
+	Disable *bool
+
+	Width  *int
+	Height *int
+
+	MarginWidth  *int `yaml:"margin_width"`
+	MarginHeight *int `yaml:"margin_height"`
+
+	Align         *string
+	VerticalAlign *string `yaml:"vertical_align"`
+	LineBreaking  *string `yaml:"line_breaking"`
+}
+
+// override the value by the layout's value, if set.
+func override[T any](value *T, layout *T) {
+	if layout != nil {
+		*value = *layout
+	}
+}
+
+// apply the layout to the canvas.
+func (layout canvasLayoutConf) apply(canvas canvasConf) canvasConf {
+	override(&canvas.Width, layout.Width)
+	override(&canvas.Height, layout.Height)
+	return canvas
+}
+
+// apply the layout to the picture box.
+func (layout pictureBoxLayoutConf) apply(box pictureBoxConf) pictureBoxConf {
+	override(&box.Width, layout.Width)
+	override(&box.Height, layout.Height)
+	override(&box.Grayscale, layout.Grayscale)
+	override(&box.Crop, layout.Crop)
+	override(&box.Fit, layout.Fit)
+	override(&box.Background.Color, layout.Background.Color)
+	override(&box.Background.GradientTo, layout.Background.GradientTo)
+	override(&box.Mask, layout.Mask)
+	override(&box.Radius, layout.Radius)
+	return box
+}
+
+// apply the layout to the box.
+func (layout boxLayoutConf) apply(box messageBoxConf) messageBoxConf {
+	override(&box.Disable, layout.Disable)
+	override(&box.Width, layout.Width)
+	override(&box.Height, layout.Height)
+	override(&box.MarginWidth, layout.MarginWidth)
+	override(&box.MarginHeight, layout.MarginHeight)
+	override(&box.Align, layout.Align)
+	override(&box.VerticalAlign, layout.VerticalAlign)
+	override(&box.LineBreaking, layout.LineBreaking)
+	return box
+}
+
+// defaultLayout names the template's own geometry, while allLayouts requests
+// every layout at once.
+const (
+	defaultLayout = "default"
+	allLayouts    = "all"
+)
+
+// layoutNames of the template, starting with the defaultLayout.
+func (conf sharepicConf) layoutNames() []string {
+	names := make([]string, 0, len(conf.Layouts))
+	for name := range conf.Layouts {
+		if name != defaultLayout {
+			names = append(names, name)
 		}
+	}
+	sort.Strings(names)
+	return append([]string{defaultLayout}, names...)
+}
+
+// withLayout returns the configuration with the geometry of the named layout
+// applied, next to the SVG template's name without its file extension.
+func (conf sharepicConf) withLayout(templateName, layout string) (sharepicConf, string, error) {
+	if layout == "" || layout == defaultLayout {
+		return conf, templateName, nil
+	}
+
+	layoutConf, ok := conf.Layouts[layout]
+	if !ok {
+		return sharepicConf{}, "", fmt.Errorf("no layout %q available for template %q", layout, templateName)
+	}
 
-		ymlConf, ymlConfErr := templatesFs.Open(filepath.Join("inc/templates", entry.Name()))
-		if ymlConfErr != nil {
-			log.Fatalf("cannot open template configuration %v, %v", entry, ymlConfErr)
+	conf.Sharepic = layoutConf.Sharepic.apply(conf.Sharepic)
+	conf.PictureBox = layoutConf.PictureBox.apply(conf.PictureBox)
+	conf.MessageBox = layoutConf.MessageBox.apply(conf.MessageBox)
+	if len(layoutConf.TextBoxes) > 0 {
+		// The map is copied, as it is shared with the template's configuration.
+		textBoxes := make(map[string]textBoxConf, len(conf.TextBoxes))
+		for name, textBox := range conf.TextBoxes {
+			if box, ok := layoutConf.TextBoxes[name]; ok {
+				textBox.Box = box.apply(textBox.Box)
+			}
+			textBoxes[name] = textBox
 		}
+		conf.TextBoxes = textBoxes
+	}
+	if len(layoutConf.ImageSlots) > 0 {
+		imageSlots := make(map[string]pictureBoxConf, len(conf.ImageSlots))
+		for name, slot := range conf.ImageSlots {
+			if layoutSlot, ok := layoutConf.ImageSlots[name]; ok {
+				slot = layoutSlot.apply(slot)
+			}
+			imageSlots[name] = slot
+		}
+		conf.ImageSlots = imageSlots
+	}
+	conf.Layouts = nil
+
+	svgName := templateName
+	if layoutConf.Svg != "" {
+		svgName = layoutConf.Svg
+	}
+	return conf, svgName, nil
+}
+
+//go:embed inc/templates/*
+var templatesFs embed.FS
+
+// InitSharepicConfig from the embedded template files and, if configured, the
+// templates directory. The latter will be watched for changes.
+//
+// Templates with problems are skipped, but a test run fails for any problem.
+// Warnings are only logged.
+func InitSharepicConfig() {
+	embeddedFs, err := fs.Sub(templatesFs, "inc/templates")
+	if err != nil {
+		log.Fatalf("cannot read embedded templates directory, %v", err)
+	}
 
-		var conf sharepicConf
-		decodeErr := yaml.NewDecoder(ymlConf).Decode(&conf)
-		if decodeErr != nil {
-			log.Fatalf("failed to decode YAML, %v", decodeErr)
+	load := func() (*sharepicTemplates, error) {
+		if templatesDir == "" {
+			return loadSharepicTemplates(embeddedFs)
 		}
+		return loadSharepicTemplates(embeddedFs, os.DirFS(templatesDir))
+	}
 
-		// Strip file extension, ".yml".
-		key := entry.Name()[:len(entry.Name())-4]
-		sharepicConfs[key] = conf
+	templates, err := load()
+	if err != nil {
+		log.Fatalf("cannot load templates, %v", err)
+	}
+	activeTemplates.Store(templates)
 
+	for _, key := range templates.names() {
 		log.Printf("loaded configuration for template %s", key)
 	}
+
+	templates.logProblems()
+	if len(templates.problems) > 0 && IsTestRun() {
+		log.Fatalf("template validation failed with %d problem(s)", len(templates.problems))
+	}
+
+	if templatesDir != "" {
+		go watchTemplatesDir(templatesDir, templatesReloadInterval, load)
+	}
 }
 
 // MakeSharepic creates the sharepic from the passed user input.
-func MakeSharepic(ctx context.Context, input sharepicCustomization, imageData []byte) ([]byte, error) {
-	conf, ok := sharepicConfs[input.Name]
+//
+// To create all layouts of a template at once, use MakeSharepics.
+func MakeSharepic(ctx context.Context, input sharepicCustomization, images inputImages) (sharepicImage, error) {
+	return makeSharepic(ctx, loadedTemplates(), input, images)
+}
+
+// MakeSharepics creates the sharepics for the requested layout, being either a
+// single one or, for allLayouts, every layout of the template.
+func MakeSharepics(ctx context.Context, input sharepicCustomization, images inputImages) ([]sharepicImage, error) {
+	templates := loadedTemplates()
+
+	if input.Layout != allLayouts {
+		sharepic, err := makeSharepic(ctx, templates, input, images)
+		if err != nil {
+			return nil, err
+		}
+		return []sharepicImage{sharepic}, nil
+	}
+
+	conf, ok := templates.sharepicConfs[input.Name]
 	if !ok {
 		return nil, fmt.Errorf("no template %q available", input.Name)
 	}
 
+	layoutNames := conf.layoutNames()
+	sharepics := make([]sharepicImage, 0, len(layoutNames))
+	for _, layout := range layoutNames {
+		input.Layout = layout
+
+		sharepic, err := makeSharepic(ctx, templates, input, images)
+		if err != nil {
+			return nil, fmt.Errorf("layout %q, %w", layout, err)
+		}
+		sharepics = append(sharepics, sharepic)
+	}
+	return sharepics, nil
+}
+
+// makeSharepic creates a single sharepic based on the given templates.
+func makeSharepic(ctx context.Context, templates *sharepicTemplates, input sharepicCustomization, images inputImages) (sharepicImage, error) {
+	conf, ok := templates.sharepicConfs[input.Name]
+	if !ok {
+		return sharepicImage{}, fmt.Errorf("no template %q available", input.Name)
+	}
+
+	layout := input.Layout
+	if layout == "" {
+		layout = defaultLayout
+	}
+	conf, svgName, err := conf.withLayout(input.Name, layout)
+	if err != nil {
+		return sharepicImage{}, err
+	}
+	formatName := input.Format
+	if formatName == "" {
+		formatName = conf.Output.Format
+	}
+	if formatName == "" {
+		formatName = defaultOutputFormat
+	}
+	format, err := lookupOutputFormat(formatName)
+	if err != nil {
+		return sharepicImage{}, err
+	}
+	if input.Language == "" {
+		input.Language = conf.Language
+	}
+	if _, ok := lookupHyphenator(input.Language); input.Language != "" && !ok {
+		return sharepicImage{}, fmt.Errorf("unsupported language %q", input.Language)
+	}
+
+	// The message's length excludes its markup.
+	message, _ := parseMarkup(input.Message)
 	fieldLengths := []struct {
 		name   string
 		max    int
 		length int
 	}{
-		{"message", conf.MaxLength.Message, utf8.RuneCountInString(input.Message)},
+		{"message", conf.MaxLength.Message, utf8.RuneCountInString(message)},
 		{"author", conf.MaxLength.Author, utf8.RuneCountInString(input.AuthorName)},
 		{"description", conf.MaxLength.Description, utf8.RuneCountInString(input.AuthorDesc)},
 	}
 	for _, fieldLength := range fieldLengths {
 		if fieldLength.max != 0 && fieldLength.length > fieldLength.max {
-			return nil, fmt.Errorf("length of %s, %d, exceeds maximum %d",
+			return sharepicImage{}, fmt.Errorf("length of %s, %d, exceeds maximum %d",
 				fieldLength.name, fieldLength.length, fieldLength.max)
 		}
 	}
 
+	if input.Fields, err = conf.customFields(input.Fields); err != nil {
+		return sharepicImage{}, err
+	}
+
+	for name := range images.Slots {
+		if _, ok := conf.ImageSlots[name]; !ok {
+			return sharepicImage{}, fmt.Errorf("unknown image slot %q", name)
+		}
+	}
+	for _, name := range conf.slotNames() {
+		if len(images.Slots[name]) == 0 && !conf.ImageSlots[name].Optional {
+			return sharepicImage{}, fmt.Errorf("missing image for slot %q", name)
+		}
+	}
+	if len(images.Picture) == 0 && !conf.PictureBox.Optional && !conf.PictureBox.Disable {
+		return sharepicImage{}, fmt.Errorf("missing picture")
+	}
+
 	gen := generator{
 		sharepicTempl: conf,
+		svgTemplate:   templates.sharepicTemplate,
+		svgName:       svgName + ".svg",
 		customization: input,
-		imageData:     imageData,
+		images:        images,
+		format:        format,
 	}
 
-	return gen.GenSharepic(ctx)
+	data, err := gen.GenSharepic(ctx)
+	if err != nil {
+		return sharepicImage{}, err
+	}
+	return sharepicImage{Layout: layout, Data: data, Format: format}, nil
 }
@@ -0,0 +1,387 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the preparation of right-to-left and bidirectional text,
+// e.g., Arabic, Hebrew, or Persian. Unless ImageMagick performs the text layout
+// itself, Arabic letters are shaped into their contextual presentation forms
+// and each line is reordered visually, following the Unicode Bidirectional
+// Algorithm.
+
+package main
+
+import (
+	"strings"
+	"sync"
+
+	"golang.org/x/text/unicode/bidi"
+	"gopkg.in/gographics/imagick.v3/imagick"
+)
+
+var (
+	nativeBidiOverlay bool
+	nativeBidiSvg     bool
+	nativeBidiOnce    sync.Once
+)
+
+// nativeBidi reports whether ImageMagick performs bidi reordering and shaping
+// itself, for the overlay text and for the SVG's texts.
+//
+// The overlay is drawn by FreeType, being only capable with libraqm. SVG files
+// are rendered by librsvg, if available, or otherwise by the same drawing code.
+func nativeBidi() (overlay, svg bool) {
+	nativeBidiOnce.Do(func() {
+		mw := imagick.NewMagickWand()
+		defer mw.Destroy()
+
+		delegates, _ := mw.QueryConfigureOption("DELEGATES")
+		for _, delegate := range strings.Fields(delegates) {
+			switch delegate {
+			case "raqm":
+				nativeBidiOverlay, nativeBidiSvg = true, true
+			case "rsvg":
+				nativeBidiSvg = true
+			}
+		}
+	})
+	return nativeBidiOverlay, nativeBidiSvg
+}
+
+// isRightToLeft reports whether the text's first strong character is written
+// from right to left, determining the paragraph's direction. Style tags are
+// ignored, being private use characters of the left-to-right class.
+func isRightToLeft(text string) bool {
+	for _, r := range text {
+		if isStyleTag(r) {
+			continue
+		}
+		props, _ := bidi.LookupRune(r)
+		switch props.Class() {
+		case bidi.L:
+			return false
+		case bidi.R, bidi.AL:
+			return true
+		}
+	}
+	return false
+}
+
+// bidiMirrors are the characters being mirrored within right-to-left runs.
+var bidiMirrors = map[rune]rune{
+	'(': ')', ')': '(',
+	'[': ']', ']': '[',
+	'{': '}', '}': '{',
+	'<': '>', '>': '<',
+	'«': '»', '»': '«',
+	'‹': '›', '›': '‹',
+}
+
+// numberPrefix returns the length of the number at the runes' start, e.g.,
+// "3.14" or "5%", including separators between and terminators next to digits.
+func numberPrefix(runes []rune) int {
+	class := func(i int) bidi.Class {
+		props, _ := bidi.LookupRune(runes[i])
+		return props.Class()
+	}
+
+	n := 0
+	for n < len(runes) {
+		switch c := class(n); {
+		case c == bidi.EN || c == bidi.AN || c == bidi.ET || c == bidi.NSM:
+		case (c == bidi.CS || c == bidi.ES) && n+1 < len(runes) && (class(n+1) == bidi.EN || class(n+1) == bidi.AN):
+		default:
+			return n
+		}
+		n++
+	}
+	return n
+}
+
+// bidiLevels of a line's runes by the Unicode Bidirectional Algorithm, being
+// the paragraph's level for a neutral line.
+//
+// The levels are derived from the runs of a bidi.Paragraph, which only tell the
+// direction. Thus, left-to-right runs are raised to level 2 within a
+// right-to-left paragraph, as are numbers following right-to-left text within
+// a left-to-right paragraph.
+func bidiLevels(runes []rune, rtl bool) []int {
+	levels := make([]int, len(runes))
+
+	// As only the right-to-left direction can be set as an option, the
+	// left-to-right direction is set by a leading left-to-right mark.
+	text, offset := "\u200e"+string(runes), 1
+	var opts []bidi.Option
+	if rtl {
+		text, offset = string(runes), 0
+		opts = append(opts, bidi.DefaultDirection(bidi.RightToLeft))
+		for i := range levels {
+			levels[i] = 1
+		}
+	}
+
+	var paragraph bidi.Paragraph
+	if _, err := paragraph.SetString(text, opts...); err != nil {
+		return levels
+	}
+	ordering, err := paragraph.Order()
+	if err != nil {
+		return levels
+	}
+
+	previousRtl := false
+	for i := 0; i < ordering.NumRuns(); i++ {
+		run := ordering.Run(i)
+		start, end := run.Pos()
+		start, end = start-offset, end-offset+1
+		if start < 0 {
+			start = 0
+		}
+
+		runRtl := run.Direction() == bidi.RightToLeft
+		level := 0
+		switch {
+		case runRtl:
+			level = 1
+		case rtl:
+			level = 2
+		case previousRtl:
+			for j := start; j < start+numberPrefix(runes[start:end]); j++ {
+				levels[j] = 2
+			}
+		}
+		if level > 0 {
+			for j := start; j < end; j++ {
+				levels[j] = level
+			}
+		}
+		previousRtl = runRtl
+	}
+	return levels
+}
+
+// visualOrder of a single line of text, which direction is given by rtl.
+//
+// Style tags are left out for the bidi.Paragraph, as they would count as
+// left-to-right characters, and take their base character's level instead.
+// Afterwards, all sequences from the highest to the lowest odd level are
+// reversed. Combining marks and style tags stay behind their base characters
+// and brackets are mirrored.
+func visualOrder(text string, rtl bool) string {
+	runes := []rune(text)
+
+	base := make([]rune, 0, len(runes))
+	for _, r := range runes {
+		if !isStyleTag(r) {
+			base = append(base, r)
+		}
+	}
+	baseLevels := bidiLevels(base, rtl)
+
+	levels := make([]int, len(runes))
+	maxLevel := 0
+	for i, k := 0, 0; i < len(runes); i++ {
+		switch {
+		case !isStyleTag(runes[i]):
+			levels[i] = baseLevels[k]
+			k++
+		case i > 0:
+			levels[i] = levels[i-1]
+		}
+		if levels[i] > maxLevel {
+			maxLevel = levels[i]
+		}
+	}
+
+	// Reverse each sequence at or above a level, keeping combining marks
+	// behind their base characters.
+	for level := maxLevel; level >= 1; level-- {
+		for i := 0; i < len(runes); {
+			if levels[i] < level {
+				i++
+				continue
+			}
+
+			j := i
+			for j < len(runes) && levels[j] >= level {
+				j++
+			}
+			reverseClusters(runes[i:j], levels[i:j])
+			i = j
+		}
+	}
+
+	for i, r := range runes {
+		if mirror, ok := bidiMirrors[r]; ok && levels[i]%2 == 1 {
+			runes[i] = mirror
+		}
+	}
+
+	return string(runes)
+}
+
+// reverseClusters reverses both runes and levels in place, while a base
+// character and its following marks, see isMark, keep their order.
+func reverseClusters(runes []rune, levels []int) {
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+		levels[i], levels[j] = levels[j], levels[i]
+	}
+
+	for i := 0; i < len(runes); i++ {
+		if !isMark(runes[i]) {
+			continue
+		}
+
+		j := i
+		for j < len(runes) && isMark(runes[j]) {
+			j++
+		}
+		if j == len(runes) {
+			break
+		}
+
+		// The marks in runes[i:j] belong to the base at j, now behind it, and
+		// are in reverse order.
+		cluster := []rune{runes[j]}
+		for k := j - 1; k >= i; k-- {
+			cluster = append(cluster, runes[k])
+		}
+		copy(runes[i:j+1], cluster)
+		i = j
+	}
+}
+
+// arabicForms are the presentation forms of an Arabic letter. Letters only
+// joining to the preceding letter, e.g., Alef, have no initial and medial form.
+type arabicForms struct {
+	isolated, final, initial, medial rune
+}
+
+const (
+	arabicTatweel = 'ـ'
+	arabicLam     = 'ل'
+)
+
+// arabicLetters maps Arabic and Persian letters to their presentation forms.
+var arabicLetters = map[rune]arabicForms{
+	'ء': {0xFE80, 0, 0, 0},
+	'آ': {0xFE81, 0xFE82, 0, 0},
+	'أ': {0xFE83, 0xFE84, 0, 0},
+	'ؤ': {0xFE85, 0xFE86, 0, 0},
+	'إ': {0xFE87, 0xFE88, 0, 0},
+	'ئ': {0xFE89, 0xFE8A, 0xFE8B, 0xFE8C},
+	'ا': {0xFE8D, 0xFE8E, 0, 0},
+	'ب': {0xFE8F, 0xFE90, 0xFE91, 0xFE92},
+	'ة': {0xFE93, 0xFE94, 0, 0},
+	'ت': {0xFE95, 0xFE96, 0xFE97, 0xFE98},
+	'ث': {0xFE99, 0xFE9A, 0xFE9B, 0xFE9C},
+	'ج': {0xFE9D, 0xFE9E, 0xFE9F, 0xFEA0},
+	'ح': {0xFEA1, 0xFEA2, 0xFEA3, 0xFEA4},
+	'خ': {0xFEA5, 0xFEA6, 0xFEA7, 0xFEA8},
+	'د': {0xFEA9, 0xFEAA, 0, 0},
+	'ذ': {0xFEAB, 0xFEAC, 0, 0},
+	'ر': {0xFEAD, 0xFEAE, 0, 0},
+	'ز': {0xFEAF, 0xFEB0, 0, 0},
+	'س': {0xFEB1, 0xFEB2, 0xFEB3, 0xFEB4},
+	'ش': {0xFEB5, 0xFEB6, 0xFEB7, 0xFEB8},
+	'ص': {0xFEB9, 0xFEBA, 0xFEBB, 0xFEBC},
+	'ض': {0xFEBD, 0xFEBE, 0xFEBF, 0xFEC0},
+	'ط': {0xFEC1, 0xFEC2, 0xFEC3, 0xFEC4},
+	'ظ': {0xFEC5, 0xFEC6, 0xFEC7, 0xFEC8},
+	'ع': {0xFEC9, 0xFECA, 0xFECB, 0xFECC},
+	'غ': {0xFECD, 0xFECE, 0xFECF, 0xFED0},
+	'ف': {0xFED1, 0xFED2, 0xFED3, 0xFED4},
+	'ق': {0xFED5, 0xFED6, 0xFED7, 0xFED8},
+	'ك': {0xFED9, 0xFEDA, 0xFEDB, 0xFEDC},
+	'ل': {0xFEDD, 0xFEDE, 0xFEDF, 0xFEE0},
+	'م': {0xFEE1, 0xFEE2, 0xFEE3, 0xFEE4},
+	'ن': {0xFEE5, 0xFEE6, 0xFEE7, 0xFEE8},
+	'ه': {0xFEE9, 0xFEEA, 0xFEEB, 0xFEEC},
+	'و': {0xFEED, 0xFEEE, 0, 0},
+	'ى': {0xFEEF, 0xFEF0, 0, 0},
+	'ي': {0xFEF1, 0xFEF2, 0xFEF3, 0xFEF4},
+	'پ': {0xFB56, 0xFB57, 0xFB58, 0xFB59},
+	'چ': {0xFB7A, 0xFB7B, 0xFB7C, 0xFB7D},
+	'ژ': {0xFB8A, 0xFB8B, 0, 0},
+	'ک': {0xFB8E, 0xFB8F, 0xFB90, 0xFB91},
+	'گ': {0xFB92, 0xFB93, 0xFB94, 0xFB95},
+	'ی': {0xFBFC, 0xFBFD, 0xFBFE, 0xFBFF},
+}
+
+// arabicLamAlef maps the Alef following a Lam to the isolated and final form
+// of their mandatory ligature.
+var arabicLamAlef = map[rune][2]rune{
+	'آ': {0xFEF5, 0xFEF6},
+	'أ': {0xFEF7, 0xFEF8},
+	'إ': {0xFEF9, 0xFEFA},
+	'ا': {0xFEFB, 0xFEFC},
+}
+
+// joinsFollowing reports whether a letter connects to the following one.
+func joinsFollowing(r rune) bool {
+	forms, ok := arabicLetters[r]
+	return r == arabicTatweel || (ok && forms.initial != 0)
+}
+
+// joinsPreceding reports whether a letter connects to the preceding one.
+func joinsPreceding(r rune) bool {
+	forms, ok := arabicLetters[r]
+	return r == arabicTatweel || (ok && forms.final != 0)
+}
+
+// shapeArabic replaces each Arabic letter by its presentation form, depending
+// on its neighbors in logical order, ignoring marks in between, see isMark.
+func shapeArabic(text string) string {
+	runes := []rune(text)
+
+	neighbor := func(i, step int) rune {
+		for i += step; i >= 0 && i < len(runes); i += step {
+			if !isMark(runes[i]) {
+				return runes[i]
+			}
+		}
+		return 0
+	}
+
+	shaped := make([]rune, 0, len(runes))
+	for i := 0; i < len(runes); i++ {
+		forms, ok := arabicLetters[runes[i]]
+		if !ok {
+			shaped = append(shaped, runes[i])
+			continue
+		}
+
+		joinsPrev := joinsFollowing(neighbor(i, -1))
+
+		// The ligature takes the style of the Alef, dropping the Lam's style tag.
+		next := i + 1
+		for next < len(runes) && isStyleTag(runes[next]) {
+			next++
+		}
+		if runes[i] == arabicLam && next < len(runes) {
+			if ligature, ok := arabicLamAlef[runes[next]]; ok {
+				if joinsPrev {
+					shaped = append(shaped, ligature[1])
+				} else {
+					shaped = append(shaped, ligature[0])
+				}
+				i = next
+				continue
+			}
+		}
+
+		joinsNext := forms.initial != 0 && joinsPreceding(neighbor(i, 1))
+
+		switch {
+		case joinsPrev && joinsNext:
+			shaped = append(shaped, forms.medial)
+		case joinsPrev && forms.final != 0:
+			shaped = append(shaped, forms.final)
+		case joinsNext:
+			shaped = append(shaped, forms.initial)
+		default:
+			shaped = append(shaped, forms.isolated)
+		}
+	}
+	return string(shaped)
+}
@@ -0,0 +1,126 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the preparation of right-to-left and
+// bidirectional text.
+
+package main
+
+import (
+	"testing"
+)
+
+func TestIsRightToLeft(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"", false},
+		{"123 !", false},
+		{"hello שלום", false},
+		{"שלום hello", true},
+		{"123 مرحبا", true},
+		{"שלום", true},
+	}
+
+	for _, test := range tests {
+		if got := isRightToLeft(test.text); got != test.want {
+			t.Errorf("isRightToLeft(%q) = %v, want %v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestVisualOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		rtl  bool
+		want string
+	}{
+		{"empty", "", true, ""},
+		{"left-to-right", "abc def", false, "abc def"},
+		{"right-to-left", "אבג", true, "גבא"},
+		{"right-to-left within left-to-right", "abc אבג def", false, "abc גבא def"},
+		{"left-to-right within right-to-left", "אבג abc דהו", true, "והד abc גבא"},
+		{"number after right-to-left", "אבג 123", false, "123 גבא"},
+		{"number with separators", "אבג 12.5%", true, "12.5% גבא"},
+		{"trailing neutral in left-to-right", "hello שלום!", false, "hello םולש!"},
+		{"trailing neutral in right-to-left", "שלום world!", true, "!world םולש"},
+		{"mirrored brackets", "(אבג)", true, "(גבא)"},
+		{"brackets in left-to-right context", "abc (אבג) def", false, "abc (גבא) def"},
+		{"combining mark", "אָב", true, "באָ"},
+		{"style tags", "אב", true, "בא"},
+		{"leading style tag", "abc", false, "abc"},
+		{"styled left-to-right within right-to-left", "אב ab", true, "ab בא"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := visualOrder(test.text, test.rtl); got != test.want {
+				t.Errorf("visualOrder(%q, %v) = %q, want %q", test.text, test.rtl, got, test.want)
+			}
+		})
+	}
+}
+
+func TestReverseClusters(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"", ""},
+		{"abc", "cba"},
+		{"ab́c", "cb́a"},
+		{"a́b", "ba́"},
+	}
+
+	for _, test := range tests {
+		runes := []rune(test.text)
+		levels := make([]int, len(runes))
+		for i := range levels {
+			levels[i] = i
+		}
+
+		reverseClusters(runes, levels)
+		if got := string(runes); got != test.want {
+			t.Errorf("reverseClusters(%q) = %q, want %q", test.text, got, test.want)
+		}
+		for i := range levels {
+			if levels[i] != len(levels)-1-i {
+				t.Errorf("reverseClusters(%q) levels = %v, want reversed", test.text, levels)
+				break
+			}
+		}
+	}
+}
+
+func TestShapeArabic(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"non-Arabic", "abc", "abc"},
+		{"isolated", "ب", "ﺏ"},
+		{"initial and final", "بب", "ﺑﺐ"},
+		{"medial", "ببب", "ﺑﺒﺐ"},
+		{"non-joining preceding", "دب", "ﺩﺏ"},
+		{"tatweel", "ـب", "ـﺐ"},
+		{"Persian", "پی", "ﭘﯽ"},
+		{"lam-alef", "لا", "ﻻ"},
+		{"joined lam-alef", "سلام", "ﺳﻼﻡ"},
+		{"combining mark", "بَب", "ﺑَﺐ"},
+		{"style tag", "بب", "ﺑﺐ"},
+		{"styled lam-alef", "لا", "ﻻ"},
+		{"separate words", "ب ب", "ﺏ ﺏ"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := shapeArabic(test.text); got != test.want {
+				t.Errorf("shapeArabic(%q) = %q, want %q", test.text, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the drawing of a fitted text box onto the sharepic,
+// together with the font's text effects: marker boxes behind each line, a
+// shadow, and an outline.
+//
+// For usage, the drawTextBox function is the relevant one.
+
+package main
+
+import (
+	"fmt"
+
+	"gopkg.in/gographics/imagick.v3/imagick"
+)
+
+// placedRun is a textRun at its position on the sharepic.
+type placedRun struct {
+	textRun
+
+	X float64
+	Y float64
+}
+
+// placedLine is a line of a text box on the sharepic, consisting of its runs
+// and the bounding box of its glyphs.
+type placedLine struct {
+	Runs []placedRun
+
+	Left   float64
+	Top    float64
+	Right  float64
+	Bottom float64
+}
+
+// newPixelWand for a color, e.g., black or #ffffff, to be used as described by
+// the purpose in errors.
+func newPixelWand(color, purpose string) (*imagick.PixelWand, error) {
+	pw := imagick.NewPixelWand()
+	if !pw.SetColor(color) {
+		return nil, fmt.Errorf("cannot use color %q for %s pixel wand", color, purpose)
+	}
+	return pw, nil
+}
+
+// placeLines of a fitted text box on the sharepic. Each run is placed right
+// after the previous one, while empty lines are skipped.
+func placeLines(mw *imagick.MagickWand, family fontFamily, box messageBoxConf, layout boxLayout, lines []string) ([]placedLine, error) {
+	dw := imagick.NewDrawingWand()
+	dw.SetFontSize(float64(layout.Size))
+
+	xs, ys := layout.lineOffsets(box.Width, box.Height, box.Align, box.VerticalAlign)
+
+	placed := make([]placedLine, 0, len(lines))
+	for i, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		runs := splitFontRuns(family, line)
+		metrics, err := measureRuns(mw, dw, runs)
+		if err != nil {
+			return nil, err
+		}
+
+		x, y := float64(box.MarginWidth+xs[i]), float64(box.MarginHeight+ys[i])
+		placedLine := placedLine{
+			Left:   x,
+			Top:    y - metrics.Ascent,
+			Right:  x + metrics.Width(),
+			Bottom: y + metrics.Descent,
+		}
+		for j, run := range runs {
+			placedLine.Runs = append(placedLine.Runs, placedRun{textRun: run, X: x, Y: y})
+			x += metrics.Widths[j]
+		}
+		placed = append(placed, placedLine)
+	}
+	return placed, nil
+}
+
+// annotateRuns of all lines onto the drawing wand, moved by the offset and
+// filled by the pixel wand for each run's style.
+func annotateRuns(dw *imagick.DrawingWand, lines []placedLine, offsetX, offsetY float64, fill func(textStyle) *imagick.PixelWand) error {
+	for _, line := range lines {
+		for _, run := range line.Runs {
+			if err := dw.SetFont(run.Font); err != nil {
+				return err
+			}
+			dw.SetFillColor(fill(run.Style))
+			dw.Annotation(run.X+offsetX, run.Y+offsetY, run.Text)
+		}
+	}
+	return nil
+}
+
+// setOutlineStroke of the drawing wand for the outline's width in the given
+// color. The stroke is twice as wide as the outline, as its inner half will be
+// covered by the text itself.
+func setOutlineStroke(dw *imagick.DrawingWand, outline outlineConf, pw *imagick.PixelWand) {
+	dw.SetStrokeColor(pw)
+	dw.SetStrokeWidth(2 * outline.Width)
+	dw.SetStrokeLineJoin(imagick.LINE_JOIN_ROUND)
+}
+
+// drawMarkers behind each line of text onto the sharepic.
+func drawMarkers(mw *imagick.MagickWand, marker markerConf, lines []placedLine) error {
+	markerPw, err := newPixelWand(marker.Color, "marker's")
+	if err != nil {
+		return err
+	}
+
+	dw := imagick.NewDrawingWand()
+	dw.SetFillColor(markerPw)
+
+	padding := float64(marker.Padding)
+	for _, line := range lines {
+		dw.RoundRectangle(line.Left-padding, line.Top-padding, line.Right+padding, line.Bottom+padding, marker.Radius, marker.Radius)
+	}
+	return mw.DrawImage(dw)
+}
+
+// drawShadow of the text, including its outline, onto the sharepic. The shadow
+// is drawn onto a transparent layer first to be blurred on its own.
+func drawShadow(mw *imagick.MagickWand, font fontConf, size int, lines []placedLine) error {
+	shadowPw, err := newPixelWand(font.Shadow.Color, "shadow's")
+	if err != nil {
+		return err
+	}
+	transparentPw, err := newPixelWand("none", "shadow layer's")
+	if err != nil {
+		return err
+	}
+
+	layer := imagick.NewMagickWand()
+	if err = layer.NewImage(mw.GetImageWidth(), mw.GetImageHeight(), transparentPw); err != nil {
+		return err
+	}
+
+	dw := imagick.NewDrawingWand()
+	dw.SetFontSize(float64(size))
+	if font.Outline.Color != "" {
+		setOutlineStroke(dw, font.Outline, shadowPw)
+	}
+
+	offsetX, offsetY := float64(font.Shadow.OffsetX), float64(font.Shadow.OffsetY)
+	err = annotateRuns(dw, lines, offsetX, offsetY, func(textStyle) *imagick.PixelWand { return shadowPw })
+	if err != nil {
+		return err
+	}
+	if err = layer.DrawImage(dw); err != nil {
+		return err
+	}
+
+	if font.Shadow.Blur > 0 {
+		if err = layer.GaussianBlurImage(0, font.Shadow.Blur); err != nil {
+			return err
+		}
+	}
+
+	return mw.CompositeImage(layer, imagick.COMPOSITE_OP_OVER, true, 0, 0)
+}
+
+// drawTextBox of a fitted layout onto the sharepic, using the font's colors
+// and effects. Right-to-left text is aligned to the right, unless the box's
+// alignment is set.
+func drawTextBox(mw *imagick.MagickWand, font fontConf, box messageBoxConf, layout boxLayout, lines []string, rightToLeft bool) error {
+	if box.Align == "" && rightToLeft {
+		box.Align = alignRight
+	}
+
+	placed, err := placeLines(mw, font.family(), box, layout, lines)
+	if err != nil {
+		return err
+	}
+
+	fontPw, err := newPixelWand(font.Color, "font's")
+	if err != nil {
+		return err
+	}
+
+	// Accented parts fall back to the font's color without an accent color.
+	accentPw := fontPw
+	if font.AccentColor != "" {
+		if accentPw, err = newPixelWand(font.AccentColor, "font's accent"); err != nil {
+			return err
+		}
+	}
+
+	if font.Marker.Color != "" {
+		if err = drawMarkers(mw, font.Marker, placed); err != nil {
+			return err
+		}
+	}
+	if font.Shadow.Color != "" {
+		if err = drawShadow(mw, font, layout.Size, placed); err != nil {
+			return err
+		}
+	}
+
+	dw := imagick.NewDrawingWand()
+	dw.SetFontSize(float64(layout.Size))
+
+	// The outline is drawn below the text, being without a stroke itself.
+	if font.Outline.Color != "" {
+		outlinePw, err := newPixelWand(font.Outline.Color, "outline's")
+		if err != nil {
+			return err
+		}
+		noStrokePw, err := newPixelWand("none", "outline's")
+		if err != nil {
+			return err
+		}
+
+		setOutlineStroke(dw, font.Outline, outlinePw)
+		err = annotateRuns(dw, placed, 0, 0, func(textStyle) *imagick.PixelWand { return outlinePw })
+		if err != nil {
+			return err
+		}
+		dw.SetStrokeColor(noStrokePw)
+		dw.SetStrokeWidth(0)
+	}
+
+	err = annotateRuns(dw, placed, 0, 0, func(style textStyle) *imagick.PixelWand {
+		if style&styleAccent != 0 {
+			return accentPw
+		}
+		return fontPw
+	})
+	if err != nil {
+		return err
+	}
+
+	return mw.DrawImage(dw)
+}
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the custom fields a template might declare next to the
+// message and the author fields, e.g., a talk's title or date.
+//
+// Custom fields are available within the SVG template as .Fields, e.g.,
+// {{.Fields.talkTitle}}, and might be shown within a text box.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Types of custom fields, see fieldConf.
+const (
+	fieldTypeString = "string"
+	fieldTypeText   = "text"
+	fieldTypeNumber = "number"
+	fieldTypeDate   = "date"
+)
+
+// fieldDateLayout is the format of a date field's value.
+const fieldDateLayout = "2006-01-02"
+
+// fieldPrefix of custom fields within HTTP forms and CSV headers, e.g.,
+// "field.talkTitle".
+const fieldPrefix = "field."
+
+// namePattern restricts the names of custom fields and image slots to be
+// usable within the SVG template.
+var namePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*$`)
+
+// fieldConf describes a custom field of a template.
+type fieldConf struct {
+	// Type is either string, the default, text for multiple lines, number, or
+	// date in the YYYY-MM-DD format.
+	Type string
+
+	MaxLength int `yaml:"max_length"`
+	Default   string
+	Required  bool
+}
+
+// check reports whether the value fits the field's type and maximum length.
+func (field fieldConf) check(value string) error {
+	if length := utf8.RuneCountInString(value); field.MaxLength != 0 && length > field.MaxLength {
+		return fmt.Errorf("length %d exceeds maximum %d", length, field.MaxLength)
+	}
+
+	switch field.Type {
+	case "", fieldTypeString:
+		if strings.Contains(value, "\n") {
+			return fmt.Errorf("must be a single line")
+		}
+	case fieldTypeText:
+	case fieldTypeNumber:
+		if _, err := strconv.ParseFloat(value, 64); value != "" && err != nil {
+			return fmt.Errorf("must be a number, not %q", value)
+		}
+	case fieldTypeDate:
+		if _, err := time.Parse(fieldDateLayout, value); value != "" && err != nil {
+			return fmt.Errorf("must be a date like %s, not %q", fieldDateLayout, value)
+		}
+	default:
+		return fmt.Errorf("unsupported type %q", field.Type)
+	}
+	return nil
+}
+
+// fieldNames of the template's custom fields in a stable order.
+func (conf sharepicConf) fieldNames() []string {
+	names := make([]string, 0, len(conf.Fields))
+	for name := range conf.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// customFields checks the requested values of the template's custom fields.
+// The result contains all declared fields, using their defaults for empty or
+// missing values. Empty values of unknown fields are ignored, e.g., for a batch
+// of multiple templates.
+func (conf sharepicConf) customFields(values map[string]string) (map[string]string, error) {
+	for name, value := range values {
+		if _, ok := conf.Fields[name]; !ok && value != "" {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+	}
+
+	fields := make(map[string]string, len(conf.Fields))
+	for _, name := range conf.fieldNames() {
+		field := conf.Fields[name]
+
+		value := values[name]
+		if field.Type != fieldTypeText {
+			value = cleanInputString(value)
+		}
+		if value == "" {
+			value = field.Default
+		}
+
+		if value == "" && field.Required {
+			return nil, fmt.Errorf("field %q is required", name)
+		}
+		if err := field.check(value); err != nil {
+			return nil, fmt.Errorf("field %q, %w", name, err)
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
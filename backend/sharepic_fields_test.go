@@ -0,0 +1,135 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the templates' custom fields.
+
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFieldConfCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		field   fieldConf
+		value   string
+		wantErr bool
+	}{
+		{"string", fieldConf{}, "Free Software", false},
+		{"explicit string", fieldConf{Type: fieldTypeString}, "Free Software", false},
+		{"multiline string", fieldConf{}, "Free\nSoftware", true},
+		{"multiline text", fieldConf{Type: fieldTypeText}, "Free\nSoftware", false},
+		{"empty string", fieldConf{}, "", false},
+		{"max length", fieldConf{MaxLength: 4}, "Free", false},
+		{"max length in runes", fieldConf{MaxLength: 4}, "Frée", false},
+		{"exceeded max length", fieldConf{MaxLength: 4}, "Freedom", true},
+		{"unlimited length", fieldConf{MaxLength: 0}, "Freedom", false},
+		{"integer", fieldConf{Type: fieldTypeNumber}, "42", false},
+		{"float", fieldConf{Type: fieldTypeNumber}, "-4.2", false},
+		{"empty number", fieldConf{Type: fieldTypeNumber}, "", false},
+		{"invalid number", fieldConf{Type: fieldTypeNumber}, "forty-two", true},
+		{"date", fieldConf{Type: fieldTypeDate}, "2024-02-14", false},
+		{"leap day", fieldConf{Type: fieldTypeDate}, "2024-02-29", false},
+		{"empty date", fieldConf{Type: fieldTypeDate}, "", false},
+		{"no leap day", fieldConf{Type: fieldTypeDate}, "2023-02-29", true},
+		{"invalid month", fieldConf{Type: fieldTypeDate}, "2024-13-01", true},
+		{"different date format", fieldConf{Type: fieldTypeDate}, "14.02.2024", true},
+		{"date with time", fieldConf{Type: fieldTypeDate}, "2024-02-14T10:00:00Z", true},
+		{"unsupported type", fieldConf{Type: "choice"}, "a", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := test.field.check(test.value); (err != nil) != test.wantErr {
+				t.Errorf("check(%q) = %v, want error: %t", test.value, err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestCustomFields(t *testing.T) {
+	conf := sharepicConf{Fields: map[string]fieldConf{
+		"title":    {Required: true, MaxLength: 20},
+		"abstract": {Type: fieldTypeText},
+		"date":     {Type: fieldTypeDate, Default: "2024-02-14"},
+		"room":     {Type: fieldTypeNumber},
+	}}
+
+	tests := []struct {
+		name    string
+		values  map[string]string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name:   "defaults",
+			values: map[string]string{"title": "Talk"},
+			want:   map[string]string{"title": "Talk", "abstract": "", "date": "2024-02-14", "room": ""},
+		},
+		{
+			name:   "all values",
+			values: map[string]string{"title": "Talk", "abstract": "First\nSecond", "date": "2024-03-01", "room": "7"},
+			want:   map[string]string{"title": "Talk", "abstract": "First\nSecond", "date": "2024-03-01", "room": "7"},
+		},
+		{
+			name:   "cleaned string",
+			values: map[string]string{"title": "  Free \t Talk  ", "date": " "},
+			want:   map[string]string{"title": "Free Talk", "abstract": "", "date": "2024-02-14", "room": ""},
+		},
+		{
+			name:   "newline within string",
+			values: map[string]string{"title": "Free\nTalk"},
+			want:   map[string]string{"title": "Free Talk", "abstract": "", "date": "2024-02-14", "room": ""},
+		},
+		{
+			name:   "empty unknown field",
+			values: map[string]string{"title": "Talk", "speaker": ""},
+			want:   map[string]string{"title": "Talk", "abstract": "", "date": "2024-02-14", "room": ""},
+		},
+		{
+			name:    "unknown field",
+			values:  map[string]string{"title": "Talk", "speaker": "Jane"},
+			wantErr: true,
+		},
+		{
+			name:    "missing required",
+			values:  map[string]string{},
+			wantErr: true,
+		},
+		{
+			name:    "blank required",
+			values:  map[string]string{"title": "   "},
+			wantErr: true,
+		},
+		{
+			name:    "exceeded max length",
+			values:  map[string]string{"title": "A talk about Free Software"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid date",
+			values:  map[string]string{"title": "Talk", "date": "2024-02-30"},
+			wantErr: true,
+		},
+		{
+			name:    "invalid number",
+			values:  map[string]string{"title": "Talk", "room": "B"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, err := conf.customFields(test.values)
+			if (err != nil) != test.wantErr {
+				t.Fatalf("customFields(%v) = %v, want error: %t", test.values, err, test.wantErr)
+			}
+			if !test.wantErr && !reflect.DeepEqual(fields, test.want) {
+				t.Errorf("customFields(%v) = %v, want %v", test.values, fields, test.want)
+			}
+		})
+	}
+}
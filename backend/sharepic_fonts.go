@@ -0,0 +1,271 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the selection of fonts from a template's font chain, the
+// primary font of a text style followed by its fallbacks. Each character is
+// drawn with the first font covering it, resulting in runs of text sharing the
+// same font and style.
+//
+// As ImageMagick identifies fonts by their names, the font files are looked up
+// within the fontDirs by the names stored within the files.
+
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"unicode"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// fontFile references a font within a font file, possibly a collection.
+type fontFile struct {
+	Path  string
+	Index int
+}
+
+var (
+	fontIndex     map[string]fontFile
+	fontIndexOnce sync.Once
+
+	fonts      = make(map[string]*sfnt.Font)
+	fontsMutex sync.Mutex
+)
+
+// normalizeFontName to compare ImageMagick's font names, e.g., "Lato-Semibold",
+// with those stored within the font files, e.g., "Lato" and "Semibold".
+func normalizeFontName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return -1
+		}
+		return unicode.ToLower(r)
+	}, name)
+}
+
+// loadFontIndex of all font files within the fontDirs once, mapping the
+// normalized names of each font to its file.
+func loadFontIndex() map[string]fontFile {
+	fontIndexOnce.Do(func() {
+		fontIndex = make(map[string]fontFile)
+
+		for _, dir := range fontDirs {
+			err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				switch strings.ToLower(filepath.Ext(path)) {
+				case ".ttf", ".otf", ".ttc", ".otc":
+					indexFontFile(path)
+				}
+				return nil
+			})
+			if err != nil {
+				log.Printf("cannot index font directory %q, %v", dir, err)
+			}
+		}
+	})
+	return fontIndex
+}
+
+// indexFontFile adds each font within the file to the fontIndex.
+func indexFontFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Printf("cannot open font file %q, %v", path, err)
+		return
+	}
+	defer f.Close()
+
+	collection, err := sfnt.ParseCollectionReaderAt(f)
+	if err != nil {
+		log.Printf("cannot parse font file %q, %v", path, err)
+		return
+	}
+
+	var buf sfnt.Buffer
+	for i := 0; i < collection.NumFonts(); i++ {
+		font, err := collection.Font(i)
+		if err != nil {
+			log.Printf("cannot parse font %d of %q, %v", i, path, err)
+			continue
+		}
+
+		name := func(id sfnt.NameID) string {
+			name, _ := font.Name(&buf, id)
+			return name
+		}
+		family, subfamily := name(sfnt.NameIDFamily), name(sfnt.NameIDSubfamily)
+		typoFamily, typoSubfamily := name(sfnt.NameIDTypographicFamily), name(sfnt.NameIDTypographicSubfamily)
+
+		names := []string{
+			name(sfnt.NameIDPostScript),
+			name(sfnt.NameIDFull),
+			family + subfamily,
+			typoFamily + typoSubfamily,
+		}
+		if strings.EqualFold(subfamily, "Regular") {
+			names = append(names, family)
+		}
+		if strings.EqualFold(typoSubfamily, "Regular") {
+			names = append(names, typoFamily)
+		}
+
+		for _, name := range names {
+			key := normalizeFontName(name)
+			if _, ok := fontIndex[key]; key != "" && !ok {
+				fontIndex[key] = fontFile{Path: path, Index: i}
+			}
+		}
+	}
+}
+
+// lookupFont by its ImageMagick name, returning nil if it is unknown.
+//
+// The font files of looked up fonts are kept open to read their glyphs.
+func lookupFont(name string) *sfnt.Font {
+	fontsMutex.Lock()
+	defer fontsMutex.Unlock()
+
+	if font, ok := fonts[name]; ok {
+		return font
+	}
+
+	var font *sfnt.Font
+	defer func() { fonts[name] = font }()
+
+	ref, ok := loadFontIndex()[normalizeFontName(name)]
+	if !ok {
+		log.Printf("cannot find font file for %q, assuming it covers the Latin script only", name)
+		return nil
+	}
+
+	f, err := os.Open(ref.Path)
+	if err != nil {
+		log.Printf("cannot open font file %q, %v", ref.Path, err)
+		return nil
+	}
+	collection, err := sfnt.ParseCollectionReaderAt(f)
+	if err != nil {
+		log.Printf("cannot parse font file %q, %v", ref.Path, err)
+		f.Close()
+		return nil
+	}
+	if font, err = collection.Font(ref.Index); err != nil {
+		log.Printf("cannot parse font %d of %q, %v", ref.Index, ref.Path, err)
+		f.Close()
+	}
+	return font
+}
+
+// fontExists reports whether a font file for the ImageMagick font name is found
+// within the fontDirs.
+func fontExists(name string) bool {
+	_, ok := loadFontIndex()[normalizeFontName(name)]
+	return ok
+}
+
+// fontCovers reports whether the font has a glyph for the rune. Unknown fonts,
+// being nil, are assumed to cover the Latin script and common characters like
+// digits and punctuation, leaving other scripts to the fallbacks.
+func fontCovers(font *sfnt.Font, r rune, buf *sfnt.Buffer) bool {
+	if font == nil {
+		return unicode.In(r, unicode.Latin, unicode.Common, unicode.Inherited)
+	}
+	index, err := font.GlyphIndex(buf, r)
+	return err == nil && index != 0
+}
+
+// fontFamily is a template's font for each style, see textStyle, and the
+// fallbacks shared by all of them. Missing styles use the regular font.
+type fontFamily struct {
+	Regular    string
+	Bold       string
+	Italic     string
+	BoldItalic string
+
+	Fallbacks []string
+
+	// fonts by their names, see resolve.
+	fonts map[string]*sfnt.Font
+}
+
+// resolve the family's fonts once, e.g., for a text box, as each lookup of a
+// font requires a lock.
+func (family fontFamily) resolve() fontFamily {
+	names := append([]string{family.Regular, family.Bold, family.Italic, family.BoldItalic}, family.Fallbacks...)
+
+	family.fonts = make(map[string]*sfnt.Font, len(names))
+	for _, name := range names {
+		if _, ok := family.fonts[name]; name != "" && !ok {
+			family.fonts[name] = lookupFont(name)
+		}
+	}
+	return family
+}
+
+// chain of fonts for a style, its font followed by the fallbacks.
+func (family fontFamily) chain(style textStyle) []string {
+	font := family.Regular
+	switch bold, italic := style&styleBold != 0, style&styleItalic != 0; {
+	case bold && italic && family.BoldItalic != "":
+		font = family.BoldItalic
+	case bold && family.Bold != "":
+		font = family.Bold
+	case italic && family.Italic != "":
+		font = family.Italic
+	}
+	return append([]string{font}, family.Fallbacks...)
+}
+
+// textRun is a part of a line drawn with one font and style.
+type textRun struct {
+	Text  string
+	Font  string
+	Style textStyle
+}
+
+// splitFontRuns of a text with style tags for a resolved font family. Each
+// character is assigned to the first font of its style's chain covering it, or
+// to the style's font if none does. Spaces and combining characters stay within the
+// current run.
+func splitFontRuns(family fontFamily, text string) (runs []textRun) {
+	runes, styles := unmarkStyles(text)
+	if len(runes) == 0 {
+		return []textRun{{Font: family.Regular}}
+	}
+
+	var buf sfnt.Buffer
+	var current strings.Builder
+	run := textRun{Font: family.Regular, Style: styles[0]}
+
+	for i, r := range runes {
+		runeRun := run
+		if !unicode.IsSpace(r) && !unicode.In(r, unicode.Mn, unicode.Me, unicode.Cf, unicode.Variation_Selector) {
+			chain := family.chain(styles[i])
+			runeRun = textRun{Font: chain[0], Style: styles[i]}
+			for _, candidate := range chain {
+				if len(chain) == 1 || fontCovers(family.fonts[candidate], r, &buf) {
+					runeRun.Font = candidate
+					break
+				}
+			}
+		}
+
+		if runeRun != run && i > 0 {
+			run.Text = current.String()
+			runs = append(runs, run)
+			current.Reset()
+		}
+		run = runeRun
+		current.WriteRune(r)
+	}
+	run.Text = current.String()
+	return append(runs, run)
+}
@@ -0,0 +1,86 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the font selection for the shipped templates.
+
+package main
+
+import (
+	"io/fs"
+	"sync"
+	"testing"
+	"unicode"
+
+	"golang.org/x/image/font/sfnt"
+)
+
+// useFontDirs replaces the fontDirs for a test, resetting the font lookups.
+func useFontDirs(t *testing.T, dirs ...string) {
+	t.Helper()
+
+	reset := func(dirs []string) {
+		fontsMutex.Lock()
+		defer fontsMutex.Unlock()
+
+		fontDirs = dirs
+		fontIndex, fontIndexOnce = nil, sync.Once{}
+		fonts = make(map[string]*sfnt.Font)
+	}
+
+	previous := fontDirs
+	reset(dirs)
+	t.Cleanup(func() { reset(previous) })
+}
+
+// TestShippedTemplatesCoverScripts checks that every character of right-to-left
+// scripts is assigned to a shipped font having its glyph, for each text box of
+// the shipped templates.
+func TestShippedTemplatesCoverScripts(t *testing.T) {
+	useFontDirs(t, "inc/fonts")
+
+	embeddedFs, err := fs.Sub(templatesFs, "inc/templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates, err := loadSharepicTemplates(embeddedFs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	texts := []string{
+		"مرحبا بالعالم",
+		shapeArabic("مرحبا بالعالم"),
+		shapeArabic("سلام دنیا"),
+		"שלום עולם",
+	}
+
+	var buf sfnt.Buffer
+	for _, name := range templates.names() {
+		conf := templates.sharepicConfs[name]
+
+		fonts := make(map[string]fontConf)
+		if !conf.MessageBox.Disable {
+			fonts["font"] = conf.Font
+		}
+		for _, textBox := range conf.textBoxNames() {
+			fonts["text_boxes."+textBox+".font"] = conf.TextBoxes[textBox].Font
+		}
+
+		for field, font := range fonts {
+			family := font.family()
+			for _, text := range texts {
+				for _, run := range splitFontRuns(family, text) {
+					for _, r := range run.Text {
+						if unicode.IsSpace(r) {
+							continue
+						}
+						if f := family.fonts[run.Font]; f == nil || !fontCovers(f, r, &buf) {
+							t.Errorf("%s: %s: %q of %q is assigned to %q, missing its glyph", name, field, r, text, run.Font)
+						}
+					}
+				}
+			}
+		}
+	}
+}
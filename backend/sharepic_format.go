@@ -0,0 +1,90 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the supported output formats for created sharepics and
+// their encoding.
+//
+// For usage, look up a format in outputFormats and pass it to encodeSharepic.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/gographics/imagick.v3/imagick"
+)
+
+// defaultOutputFormat is used if neither the request nor the template names
+// an output format.
+const defaultOutputFormat = "jpeg"
+
+// outputFormat describes how a sharepic is encoded and delivered.
+type outputFormat struct {
+	Name      string
+	Magick    string
+	Mime      string
+	Extension string
+
+	Compression imagick.CompressionType
+	Quality     uint
+}
+
+// outputFormats maps the lower case name of each supported format to its
+// description. The name is used both in the form and in the YAML files.
+var outputFormats = map[string]outputFormat{
+	"jpeg": {"jpeg", "JPEG", "image/jpeg", "jpg", imagick.COMPRESSION_JPEG, 80},
+	"png":  {"png", "PNG", "image/png", "png", imagick.COMPRESSION_ZIP, 95},
+	"webp": {"webp", "WEBP", "image/webp", "webp", imagick.COMPRESSION_UNDEFINED, 90},
+	"avif": {"avif", "AVIF", "image/avif", "avif", imagick.COMPRESSION_UNDEFINED, 70},
+	"pdf":  {"pdf", "PDF", "application/pdf", "pdf", imagick.COMPRESSION_ZIP, 95},
+}
+
+// lookupOutputFormat by its case insensitive name.
+func lookupOutputFormat(name string) (outputFormat, error) {
+	format, ok := outputFormats[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(outputFormats))
+		for name := range outputFormats {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		return outputFormat{}, fmt.Errorf("unsupported output format %q, use one of %s", name, strings.Join(names, ", "))
+	}
+	return format, nil
+}
+
+// outputFormatByExtension returns the format for a file extension, e.g., ".png".
+func outputFormatByExtension(ext string) (outputFormat, bool) {
+	ext = strings.TrimPrefix(strings.ToLower(ext), ".")
+	for _, format := range outputFormats {
+		if format.Extension == ext || format.Name == ext {
+			return format, true
+		}
+	}
+	return outputFormat{}, false
+}
+
+// encodeSharepic returns the image of the magick wand in the given format.
+func encodeSharepic(mw *imagick.MagickWand, format outputFormat) (data []byte, err error) {
+	if err = mw.SetImageFormat(format.Magick); err != nil {
+		return
+	}
+	if format.Compression != imagick.COMPRESSION_UNDEFINED {
+		if err = mw.SetCompression(format.Compression); err != nil {
+			return
+		}
+	}
+	if err = mw.SetCompressionQuality(format.Quality); err != nil {
+		return
+	}
+
+	data = mw.GetImageBlob()
+	if len(data) == 0 {
+		return nil, fmt.Errorf("cannot encode sharepic as %s", format.Name)
+	}
+	return
+}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"text/template"
 
 	"gopkg.in/gographics/imagick.v3/imagick"
 )
@@ -27,51 +28,99 @@ import (
 // For usage, only the GenSharepic method is relevant.
 type generator struct {
 	sharepicTempl sharepicConf
+	svgTemplate   *template.Template
+	svgName       string
 	customization sharepicCustomization
-	imageData     []byte
+	images        inputImages
+	format        outputFormat
 
 	// The values below MUST NOT be set as they are populated during execution.
 
-	fontSize   int
-	lineHeight int
-
 	tmpfileSvg *bytes.Buffer
-	lines      []string
+	boxes      []fittedBox
+}
+
+// fittedBox is a text box with its text fitted in, ready to be drawn.
+type fittedBox struct {
+	Font        fontConf
+	Box         messageBoxConf
+	Layout      boxLayout
+	Lines       []string
+	RightToLeft bool
 }
 
 // prepareInputText to mitigate SVG injections and perform uppercase conversions.
+//
+// Fields shown within the SVG are reordered for right-to-left text, unless the
+// SVG renderer supports this itself. The message is handled in findOptParams.
 func (gen *generator) prepareInputText() error {
+	_, nativeSvg := nativeBidi()
+
+	prepare := func(input string, xmlEscape, bidi bool) (string, error) {
+		if gen.sharepicTempl.Font.Uppercase {
+			input = strings.ToUpper(input)
+		}
+
+		if bidi {
+			input = visualOrder(shapeArabic(input), isRightToLeft(input))
+		}
+
+		if xmlEscape {
+			var buff bytes.Buffer
+			if err := xml.EscapeText(&buff, []byte(input)); err != nil {
+				return "", fmt.Errorf("cannot escape XML string, %w", err)
+			}
+			input = buff.String()
+		}
+
+		return input, nil
+	}
+
 	fields := []struct {
 		input     *string
 		xmlEscape bool
+		bidi      bool
 	}{
-		{&gen.customization.Message, false},
-		{&gen.customization.AuthorName, true},
-		{&gen.customization.AuthorDesc, true},
+		{&gen.customization.Message, false, false},
+		{&gen.customization.AuthorName, true, !nativeSvg},
+		{&gen.customization.AuthorDesc, true, !nativeSvg},
 	}
+
 	for _, field := range fields {
-		if gen.sharepicTempl.Font.Uppercase {
-			*field.input = strings.ToUpper(*field.input)
+		var err error
+		if *field.input, err = prepare(*field.input, field.xmlEscape, field.bidi); err != nil {
+			return err
 		}
+	}
 
-		if field.xmlEscape {
-			var buff bytes.Buffer
-			if err := xml.EscapeText(&buff, []byte(*field.input)); err != nil {
-				return fmt.Errorf("cannot escape XML string, %w", err)
-			}
-			*field.input = buff.String()
+	// The custom fields are prepared within a copy, as the map is shared with
+	// the unprepared input.
+	customFields := make(map[string]string, len(gen.customization.Fields))
+	for name, value := range gen.customization.Fields {
+		var err error
+		if customFields[name], err = prepare(value, true, !nativeSvg); err != nil {
+			return err
 		}
 	}
+	gen.customization.Fields = customFields
 
 	return nil
 }
 
-// prepareInputImage rotates and crops the user submitted picture, the returned
-// byte slice contains a JPEG image.
-func (gen *generator) prepareInputImage() (picData []byte, err error) {
+// prepareInputImage rotates and fits a user submitted picture into its box, the
+// returned byte slice contains a JPEG image, or a PNG image for a masked box.
+//
+// The crop is only used by the default cover fit mode.
+func prepareInputImage(imageData []byte, box pictureBoxConf, crop pictureCrop) (picData []byte, err error) {
 	mw := imagick.NewMagickWand()
 
-	if err = mw.ReadImageBlob(gen.imageData); err != nil {
+	if err = mw.ReadImageBlob(imageData); err != nil {
+		return
+	}
+
+	// Normalize the orientation first, as the crop must be calculated on the
+	// visually correct picture, e.g., for a rotated phone photo.
+	if err = mw.AutoOrientImage(); err != nil {
 		return
 	}
 
@@ -79,63 +128,95 @@ func (gen *generator) prepareInputImage() (picData []byte, err error) {
 		return
 	}
 
-	if gen.sharepicTempl.PictureBox.Grayscale {
+	if box.Grayscale {
 		if err = mw.TransformImageColorspace(imagick.COLORSPACE_GRAY); err != nil {
 			return
 		}
 	}
 
-	// Calculate two possible dimensions of the original image, normalized to a
-	// multiple of the desirable value. One side has to fit.
-	wishWidth, wishHeight := 4*float64(gen.sharepicTempl.PictureBox.Width), 4*float64(gen.sharepicTempl.PictureBox.Height)
-	baseWidth, baseHeight := float64(mw.GetImageWidth()), float64(mw.GetImageHeight())
-
-	wOptX, wOptY := wishWidth, (wishWidth/baseWidth)*baseHeight
-	hOptX, hOptY := (wishHeight/baseHeight)*baseWidth, wishHeight
+	// The picture is prepared at a multiple of the desirable value.
+	wishWidth, wishHeight := 4*float64(box.Width), 4*float64(box.Height)
 
-	// Continue with the greater resolution. The image will be resized to this
-	// value. However, most likely one side will be too long.
-	wSize, hSize := wOptX*wOptY, hOptX*hOptY
-
-	var x, y uint
-	if wSize >= hSize {
-		x, y = uint(wOptX), uint(wOptY)
+	if box.Fit == fitContain || box.Fit == fitBlur {
+		if mw, err = containPicture(mw, box, wishWidth, wishHeight); err != nil {
+			return
+		}
 	} else {
-		x, y = uint(hOptX), uint(hOptY)
-	}
+		// Crop the region around the focal point first and resize it afterwards.
+		baseWidth, baseHeight := float64(mw.GetImageWidth()), float64(mw.GetImageHeight())
 
-	if err = mw.ResizeImage(x, y, imagick.FILTER_GAUSSIAN); err != nil {
-		return
-	}
+		cropWidth, cropHeight, cropX, cropY := crop.region(baseWidth, baseHeight, wishWidth, wishHeight)
 
-	// Finally, crop the image to the desired resolution to the center.
-	cropX, cropY := int(x/2-uint(wishWidth/2)), int(y/2-uint(wishHeight/2))
+		if err = mw.CropImage(cropWidth, cropHeight, cropX, cropY); err != nil {
+			return
+		}
+		if err = mw.SetImagePage(0, 0, 0, 0); err != nil {
+			return
+		}
 
-	if err = mw.CropImage(uint(wishWidth), uint(wishHeight), cropX, cropY); err != nil {
-		return
+		if err = mw.ResizeImage(uint(wishWidth), uint(wishHeight), imagick.FILTER_GAUSSIAN); err != nil {
+			return
+		}
 	}
 
-	if err = mw.AutoOrientImage(); err != nil {
-		return
+	if box.Mask != "" {
+		if err = maskPicture(mw, box); err != nil {
+			return
+		}
 	}
 
 	picData = mw.GetImageBlob()
 	return
 }
 
+// preparePicture for the box, being the user submitted picture or, if none was
+// submitted, the box's placeholder or its fill.
+func preparePicture(imageData []byte, box pictureBoxConf, crop pictureCrop) ([]byte, error) {
+	switch {
+	case len(imageData) > 0:
+		return prepareInputImage(imageData, box, crop)
+	case len(box.placeholderData) > 0:
+		return prepareInputImage(box.placeholderData, box, box.crop(defaultPictureCrop))
+	default:
+		return fillPicture(box)
+	}
+}
+
 // createTemplate from the inc/templates/*.svg file.
 func (gen *generator) createTemplate(ctx context.Context) error {
-	picData, err := gen.prepareInputImage()
-	if err != nil {
-		return err
+	// A disabled picture box results in an empty ImageData.
+	var picData []byte
+	var err error
+	if box := gen.sharepicTempl.PictureBox; !box.Disable {
+		if picData, err = preparePicture(gen.images.Picture, box, box.crop(gen.customization.Crop)); err != nil {
+			return err
+		}
+	}
+
+	// Image slots are cropped by their crop mode only.
+	slotsData := make(map[string][]byte, len(gen.sharepicTempl.ImageSlots))
+	for name, slot := range gen.sharepicTempl.ImageSlots {
+		if slotsData[name], err = preparePicture(gen.images.Slots[name], slot, slot.crop(defaultPictureCrop)); err != nil {
+			return fmt.Errorf("image slot %q, %w", name, err)
+		}
 	}
 
 	encChan := make(chan error, 1)
 	go func() {
 		gen.customization.ImageData = base64.StdEncoding.EncodeToString(picData)
+		if len(picData) > 0 {
+			gen.customization.ImageMime = detectMime(picData)
+		}
+
+		gen.customization.Images = make(map[string]string, len(slotsData))
+		gen.customization.ImageMimes = make(map[string]string, len(slotsData))
+		for name, slotData := range slotsData {
+			gen.customization.Images[name] = base64.StdEncoding.EncodeToString(slotData)
+			gen.customization.ImageMimes[name] = detectMime(slotData)
+		}
 
 		gen.tmpfileSvg = new(bytes.Buffer)
-		encChan <- sharepicTemplate.ExecuteTemplate(gen.tmpfileSvg, gen.customization.Template(), gen.customization)
+		encChan <- gen.svgTemplate.ExecuteTemplate(gen.tmpfileSvg, gen.svgName, gen.customization)
 	}()
 
 	select {
@@ -146,86 +227,131 @@ func (gen *generator) createTemplate(ctx context.Context) error {
 	}
 }
 
-// findOptParams for both separation of the message into line and font size.
-func (gen *generator) findOptParams(ctx context.Context) error {
-	// Can be skipped iff there is no message to be shown.
-	if gen.sharepicTempl.MessageBox.Disable {
-		return nil
+// findOptParams for both separation into lines and font size of the message
+// and each text box, based on the unprepared user input.
+func (gen *generator) findOptParams(ctx context.Context, input sharepicCustomization) error {
+	type textBox struct {
+		name string
+		font fontConf
+		box  messageBoxConf
+		text string
 	}
 
-	sentences, size, lineHeight, err := ConjureBox(
-		ctx,
-		gen.sharepicTempl.Font.Name, gen.sharepicTempl.Font.Sizes,
-		strings.Split(gen.customization.Message, " "),
-		gen.sharepicTempl.MessageBox.Width, gen.sharepicTempl.MessageBox.Height)
-	if err != nil {
-		return err
+	// The message box can be skipped iff there is no message to be shown, while
+	// text boxes are skipped for empty texts. Disabled boxes are never shown,
+	// e.g., a text box disabled by a layout.
+	var textBoxes []textBox
+	if !gen.sharepicTempl.MessageBox.Disable {
+		textBoxes = append(textBoxes, textBox{"", gen.sharepicTempl.Font, gen.sharepicTempl.MessageBox, input.Message})
+	}
+	for _, name := range gen.sharepicTempl.textBoxNames() {
+		conf := gen.sharepicTempl.TextBoxes[name]
+		if text, _ := input.textField(conf.Field); text != "" && !conf.Box.Disable {
+			textBoxes = append(textBoxes, textBox{name, conf.Font, conf.Box, text})
+		}
 	}
 
-	gen.fontSize = size
-	gen.lineHeight = lineHeight
-
-	gen.lines = make([]string, len(sentences))
-	for i := 0; i < len(gen.lines); i++ {
-		gen.lines[i] = strings.Join(sentences[i], " ")
+	var wg sync.WaitGroup
+	gen.boxes = make([]fittedBox, len(textBoxes))
+	errs := make([]error, len(textBoxes))
+	for i, box := range textBoxes {
+		wg.Add(1)
+		go func(i int, box textBox) {
+			defer wg.Done()
+			gen.boxes[i], errs[i] = gen.fitTextBox(ctx, box.font, box.box, box.text)
+		}(i, box)
 	}
+	wg.Wait()
 
+	for i, err := range errs {
+		if err != nil && textBoxes[i].name != "" {
+			return fmt.Errorf("text box %q, %w", textBoxes[i].name, err)
+		} else if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// conjureSharepic from the prepared template and the calculated parameters.
-func (gen *generator) conjureSharepic() (jpegData []byte, err error) {
-	mw := imagick.NewMagickWand()
-
-	if err = mw.ReadImageBlob(gen.tmpfileSvg.Bytes()); err != nil {
-		return
-	}
-
-	if err = mw.ResizeImage(uint(gen.sharepicTempl.Sharepic.Width), uint(gen.sharepicTempl.Sharepic.Height), imagick.FILTER_LANCZOS); err != nil {
-		return
+// fitTextBox finds the lines and the font size for a text within a box.
+func (gen *generator) fitTextBox(ctx context.Context, font fontConf, box messageBoxConf, text string) (fittedBox, error) {
+	if font.Uppercase {
+		text = strings.ToUpper(text)
 	}
 
-	if !gen.sharepicTempl.MessageBox.Disable {
-		dw := imagick.NewDrawingWand()
-		dw.SetFontSize(float64(gen.fontSize))
+	nativeOverlay, _ := nativeBidi()
+	text, styles := parseMarkup(text)
 
-		if err = dw.SetFont(gen.sharepicTempl.Font.Name); err != nil {
-			return
+	var (
+		words = styleWords(splitWords(text), styles)
+		opts  = boxOptions{
+			Fonts:    font.family(),
+			Width:    box.Width,
+			Height:   box.Height,
+			Balanced: box.LineBreaking == lineBreakingBalanced,
 		}
 
-		dwPw := imagick.NewPixelWand()
-		if !dwPw.SetColor(gen.sharepicTempl.Font.Color) {
-			return nil, fmt.Errorf("cannot use color %q for font's pixel wand", gen.sharepicTempl.Font.Color)
+		fitted = fittedBox{Font: font, Box: box, RightToLeft: isRightToLeft(text)}
+		err    error
+	)
+	if !nativeOverlay {
+		for i := range words {
+			words[i] = shapeArabic(words[i])
 		}
-		dw.SetFillColor(dwPw)
+	}
 
-		for i, line := range gen.lines {
-			dw.Annotation(
-				float64(gen.sharepicTempl.MessageBox.MarginWidth),
-				float64(gen.sharepicTempl.MessageBox.MarginHeight+((i+1)*gen.lineHeight)), line)
+	conjure := func(opts boxOptions) (boxLayout, error) {
+		if font.SizeRange.Max > 0 {
+			return ConjureBoxRange(ctx, opts, font.SizeRange.Min, font.SizeRange.Max, words)
 		}
+		return ConjureBox(ctx, opts, font.Sizes, words)
+	}
 
-		if err = mw.DrawImage(dw); err != nil {
-			return
+	// Words are only hyphenated if the text does not fit otherwise.
+	fitted.Layout, err = conjure(opts)
+	if err != nil && gen.customization.Language != "" && ctx.Err() == nil {
+		opts.Language = gen.customization.Language
+		fitted.Layout, err = conjure(opts)
+	}
+	if err != nil {
+		return fittedBox{}, err
+	}
+
+	fitted.Lines = make([]string, len(fitted.Layout.Sentences))
+	for i, sentence := range fitted.Layout.Sentences {
+		fitted.Lines[i] = joinWords(sentence)
+		if !nativeOverlay {
+			fitted.Lines[i] = visualOrder(fitted.Lines[i], fitted.RightToLeft)
 		}
 	}
 
-	if err = mw.StripImage(); err != nil {
+	return fitted, nil
+}
+
+// conjureSharepic from the prepared template and the calculated parameters,
+// encoded in the generator's output format.
+func (gen *generator) conjureSharepic() (data []byte, err error) {
+	mw := imagick.NewMagickWand()
+
+	if err = mw.ReadImageBlob(gen.tmpfileSvg.Bytes()); err != nil {
 		return
 	}
 
-	if err = mw.SetImageFormat("JPEG"); err != nil {
+	if err = mw.ResizeImage(uint(gen.sharepicTempl.Sharepic.Width), uint(gen.sharepicTempl.Sharepic.Height), imagick.FILTER_LANCZOS); err != nil {
 		return
 	}
-	if err = mw.SetCompression(imagick.COMPRESSION_JPEG); err != nil {
-		return
+
+	for _, fitted := range gen.boxes {
+		if err = drawTextBox(mw, fitted.Font, fitted.Box, fitted.Layout, fitted.Lines, fitted.RightToLeft); err != nil {
+			return
+		}
 	}
-	if err = mw.SetCompressionQuality(80); err != nil {
+
+	if err = mw.StripImage(); err != nil {
 		return
 	}
 
-	jpegData = mw.GetImageBlob()
-	return
+	return encodeSharepic(mw, gen.format)
 }
 
 // GenSharepic based on the initial generator state.
@@ -233,6 +359,8 @@ func (gen *generator) GenSharepic(ctx context.Context) ([]byte, error) {
 	var wg sync.WaitGroup
 	var errCustomize, errOptimize error
 
+	// The text boxes are fitted from the input, not prepared for the SVG.
+	input := gen.customization
 	if err := gen.prepareInputText(); err != nil {
 		return nil, err
 	}
@@ -240,7 +368,7 @@ func (gen *generator) GenSharepic(ctx context.Context) ([]byte, error) {
 	wg.Add(2)
 
 	go func() { errCustomize = gen.createTemplate(ctx); wg.Done() }()
-	go func() { errOptimize = gen.findOptParams(ctx); wg.Done() }()
+	go func() { errOptimize = gen.findOptParams(ctx, input); wg.Done() }()
 
 	wg.Wait()
 
@@ -0,0 +1,194 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the picture preparation against the installed
+// ImageMagick, including fixtures of pictures with EXIF orientations.
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/gographics/imagick.v3/imagick"
+)
+
+// TestMain runs the tests under the repository's policy.xml, as installed in
+// the containers, which is picked up through MAGICK_CONFIGURE_PATH.
+func TestMain(m *testing.M) {
+	incDir, err := filepath.Abs("inc")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cannot resolve inc directory, %v\n", err)
+		os.Exit(1)
+	}
+	if err := os.Setenv("MAGICK_CONFIGURE_PATH", incDir); err != nil {
+		fmt.Fprintf(os.Stderr, "cannot set MAGICK_CONFIGURE_PATH, %v\n", err)
+		os.Exit(1)
+	}
+
+	imagick.Initialize()
+	code := m.Run()
+	imagick.Terminate()
+	os.Exit(code)
+}
+
+// withExifOrientation inserts an APP1 segment with an EXIF orientation tag
+// directly after the JPEG's SOI marker.
+func withExifOrientation(jpegData []byte, orientation uint16) ([]byte, error) {
+	if len(jpegData) < 2 || jpegData[0] != 0xff || jpegData[1] != 0xd8 {
+		return nil, fmt.Errorf("data does not start with a JPEG SOI marker")
+	}
+
+	var exif bytes.Buffer
+	exif.WriteString("Exif\x00\x00")
+	exif.WriteString("MM\x00\x2a")                                      // big endian TIFF header
+	_ = binary.Write(&exif, binary.BigEndian, uint32(8))                // offset of the first IFD
+	_ = binary.Write(&exif, binary.BigEndian, uint16(1))                // one IFD entry
+	_ = binary.Write(&exif, binary.BigEndian, uint16(0x0112))           // orientation tag
+	_ = binary.Write(&exif, binary.BigEndian, uint16(3))                // type SHORT
+	_ = binary.Write(&exif, binary.BigEndian, uint32(1))                // one value
+	_ = binary.Write(&exif, binary.BigEndian, []uint16{orientation, 0}) // value, padded
+	_ = binary.Write(&exif, binary.BigEndian, uint32(0))                // no further IFD
+
+	var out bytes.Buffer
+	out.Write(jpegData[:2])
+	out.Write([]byte{0xff, 0xe1})
+	_ = binary.Write(&out, binary.BigEndian, uint16(exif.Len()+2))
+	out.Write(exif.Bytes())
+	out.Write(jpegData[2:])
+	return out.Bytes(), nil
+}
+
+// orientationFixture creates a JPEG for one of the eight EXIF orientations. Its
+// visually correct, auto-oriented form is a 300x200 blue picture with a red
+// top-left quarter. Thus, the stored pixels are transformed inversely.
+func orientationFixture(t *testing.T, orientation uint16) []byte {
+	t.Helper()
+
+	mw := imagick.NewMagickWand()
+
+	bluePw, redPw := imagick.NewPixelWand(), imagick.NewPixelWand()
+	bluePw.SetColor("blue")
+	redPw.SetColor("red")
+
+	if err := mw.NewImage(300, 200, bluePw); err != nil {
+		t.Fatal(err)
+	}
+
+	dw := imagick.NewDrawingWand()
+	dw.SetFillColor(redPw)
+	dw.Rectangle(0, 0, 149, 99)
+	if err := mw.DrawImage(dw); err != nil {
+		t.Fatal(err)
+	}
+
+	// Inverse of the transformation performed by AutoOrientImage.
+	var err error
+	switch orientation {
+	case 1:
+	case 2:
+		err = mw.FlopImage()
+	case 3:
+		err = mw.RotateImage(bluePw, 180)
+	case 4:
+		err = mw.FlipImage()
+	case 5:
+		err = mw.TransposeImage()
+	case 6:
+		err = mw.RotateImage(bluePw, 270)
+	case 7:
+		err = mw.TransverseImage()
+	case 8:
+		err = mw.RotateImage(bluePw, 90)
+	default:
+		err = fmt.Errorf("invalid orientation %d", orientation)
+	}
+	if err != nil {
+		t.Fatalf("cannot create fixture for orientation %d, %v", orientation, err)
+	}
+
+	if err := mw.SetImageFormat("JPEG"); err != nil {
+		t.Fatal(err)
+	}
+	fixture, err := withExifOrientation(mw.GetImageBlob(), orientation)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fixture
+}
+
+// readPicture of a prepared picture, checking its size.
+func readPicture(t *testing.T, picData []byte, width, height uint) *imagick.MagickWand {
+	t.Helper()
+
+	mw := imagick.NewMagickWand()
+	if err := mw.ReadImageBlob(picData); err != nil {
+		t.Fatal(err)
+	}
+	if w, h := mw.GetImageWidth(), mw.GetImageHeight(); w != width || h != height {
+		t.Fatalf("picture is %dx%d instead of %dx%d", w, h, width, height)
+	}
+	return mw
+}
+
+// pixelColor names the color at a picture's position, being either
+// "transparent", "lime", "red", "blue", or empty for any other color.
+func pixelColor(t *testing.T, mw *imagick.MagickWand, x, y int) string {
+	t.Helper()
+
+	pw, err := mw.GetImagePixelColor(x, y)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case pw.GetAlpha() < 0.5:
+		return "transparent"
+	case pw.GetGreen() > 0.5:
+		return "lime"
+	case pw.GetRed() > 0.5:
+		return "red"
+	case pw.GetBlue() > 0.5:
+		return "blue"
+	}
+	return ""
+}
+
+// TestPrepareInputImageOrientation checks that the visually correct region of
+// pictures is cropped for all eight EXIF orientations.
+func TestPrepareInputImageOrientation(t *testing.T) {
+	box := pictureBoxConf{Width: 100, Height: 50}
+
+	// The cropped 300x150 region starts at y=25 of the 300x200 picture and is
+	// scaled to 400x200. Thus, only the top-left quarter must be red.
+	probes := []struct {
+		x, y  int
+		color string
+	}{
+		{20, 20, "red"},
+		{380, 20, "blue"},
+		{20, 180, "blue"},
+		{380, 180, "blue"},
+	}
+
+	for orientation := uint16(1); orientation <= 8; orientation++ {
+		t.Run(fmt.Sprintf("orientation %d", orientation), func(t *testing.T) {
+			picData, err := prepareInputImage(orientationFixture(t, orientation), box, defaultPictureCrop)
+			if err != nil {
+				t.Fatalf("cannot prepare picture, %v", err)
+			}
+
+			mw := readPicture(t, picData, 400, 200)
+			for _, probe := range probes {
+				if color := pixelColor(t, mw, probe.x, probe.y); color != probe.color {
+					t.Errorf("picture has %q instead of %q at %d,%d", color, probe.color, probe.x, probe.y)
+				}
+			}
+		})
+	}
+}
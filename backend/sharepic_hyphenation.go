@@ -0,0 +1,182 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the hyphenation of words, based on Franklin Liang's
+// algorithm as used by TeX. The pattern dictionaries are added during compile
+// time via embed, one file per language.
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+//go:embed inc/hyphenation/*.pat.txt
+var hyphenationFs embed.FS
+
+const (
+	// hyphen is appended to the parts of hyphenated words.
+	hyphen = "-"
+
+	// hyphenMinLeft and hyphenMinRight are the minimal amount of letters
+	// before resp. after a hyphen.
+	hyphenMinLeft  = 2
+	hyphenMinRight = 2
+)
+
+// hyphenator splits the words of one language based on its patterns.
+type hyphenator struct {
+	// patterns map the letters of each pattern, with dots marking the word's
+	// boundaries, to the priorities before each letter and after the last one.
+	// An odd priority allows a hyphen, an even one prohibits it.
+	patterns map[string][]int
+	maxLen   int
+}
+
+var (
+	hyphenators     map[string]*hyphenator
+	hyphenatorsOnce sync.Once
+)
+
+// loadHyphenators parses each embedded pattern file once, named by its language.
+func loadHyphenators() map[string]*hyphenator {
+	hyphenatorsOnce.Do(func() {
+		files, err := fs.Glob(hyphenationFs, "inc/hyphenation/*.pat.txt")
+		if err != nil {
+			log.Fatalf("cannot list hyphenation patterns, %v", err)
+		}
+
+		hyphenators = make(map[string]*hyphenator)
+		for _, file := range files {
+			data, err := hyphenationFs.ReadFile(file)
+			if err != nil {
+				log.Fatalf("cannot read hyphenation patterns %q, %v", file, err)
+			}
+			hyphenators[strings.TrimSuffix(path.Base(file), ".pat.txt")] = parseHyphenationPatterns(string(data))
+		}
+	})
+	return hyphenators
+}
+
+// hyphenationLanguages lists all languages having hyphenation patterns.
+func hyphenationLanguages() []string {
+	var languages []string
+	for language := range loadHyphenators() {
+		languages = append(languages, language)
+	}
+	sort.Strings(languages)
+	return languages
+}
+
+// lookupHyphenator for a language, either by its name, e.g., "de", or by a tag
+// with a region, e.g., "de-AT".
+func lookupHyphenator(language string) (*hyphenator, bool) {
+	language = strings.ToLower(language)
+	if i := strings.IndexAny(language, "-_"); i > 0 {
+		language = language[:i]
+	}
+	h, ok := loadHyphenators()[language]
+	return h, ok
+}
+
+// parseHyphenationPatterns in TeX's notation, e.g., ".ach4 at3est 4if.", being
+// separated by whitespaces.
+func parseHyphenationPatterns(data string) *hyphenator {
+	h := &hyphenator{patterns: make(map[string][]int)}
+	for _, pattern := range strings.Fields(data) {
+		var letters []rune
+		priorities := []int{0}
+		for _, r := range pattern {
+			if r >= '0' && r <= '9' {
+				priorities[len(priorities)-1] = int(r - '0')
+			} else {
+				letters = append(letters, r)
+				priorities = append(priorities, 0)
+			}
+		}
+
+		h.patterns[string(letters)] = priorities
+		if len(letters) > h.maxLen {
+			h.maxLen = len(letters)
+		}
+	}
+	return h
+}
+
+// breakpoints of a word consisting only of letters as rune offsets.
+func (h *hyphenator) breakpoints(word []rune) (points []int) {
+	letters := make([]rune, 0, len(word)+2)
+	letters = append(letters, '.')
+	for _, r := range word {
+		letters = append(letters, unicode.ToLower(r))
+	}
+	letters = append(letters, '.')
+
+	priorities := make([]int, len(letters)+1)
+	for i := range letters {
+		for j := i + 1; j <= len(letters) && j-i <= h.maxLen; j++ {
+			pattern, ok := h.patterns[string(letters[i:j])]
+			if !ok {
+				continue
+			}
+			for k, priority := range pattern {
+				if priority > priorities[i+k] {
+					priorities[i+k] = priority
+				}
+			}
+		}
+	}
+
+	// Due to the leading dot, the priority before the word's i-th rune is at i+1.
+	for i := hyphenMinLeft; i <= len(word)-hyphenMinRight; i++ {
+		if priorities[i+1]%2 == 1 {
+			points = append(points, i)
+		}
+	}
+	return
+}
+
+// syllables of a word, whose concatenation is the word itself.
+//
+// Only the letter sequences are hyphenated, leaving punctuation attached to its
+// neighbors. Existing hyphens are treated as break points as well. Thus, a part
+// ending with a hyphen must not be extended by another one.
+func (h *hyphenator) syllables(word string) []string {
+	runes := []rune(word)
+
+	var points []int
+	for start := 0; start < len(runes); {
+		if !unicode.IsLetter(runes[start]) {
+			if string(runes[start]) == hyphen && start > 0 && start < len(runes)-1 {
+				points = append(points, start+1)
+			}
+			start++
+			continue
+		}
+
+		end := start
+		for end < len(runes) && unicode.IsLetter(runes[end]) {
+			end++
+		}
+		for _, point := range h.breakpoints(runes[start:end]) {
+			points = append(points, start+point)
+		}
+		start = end
+	}
+
+	syllables := make([]string, 0, len(points)+1)
+	last := 0
+	for _, point := range points {
+		syllables = append(syllables, string(runes[last:point]))
+		last = point
+	}
+	return append(syllables, string(runes[last:]))
+}
@@ -0,0 +1,106 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the hyphenation of words.
+
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLookupHyphenator(t *testing.T) {
+	tests := []struct {
+		language string
+		ok       bool
+	}{
+		{"en", true},
+		{"de-AT", true},
+		{"fr_CA", true},
+		{"DE", true},
+		{"", false},
+		{"xx", false},
+		{"-en", false},
+	}
+
+	for _, test := range tests {
+		if _, ok := lookupHyphenator(test.language); ok != test.ok {
+			t.Errorf("lookupHyphenator(%q) = %t, want %t", test.language, ok, test.ok)
+		}
+	}
+}
+
+func TestHyphenatorSyllables(t *testing.T) {
+	tests := []struct {
+		language string
+		word     string
+		want     string
+	}{
+		{"en", "", ""},
+		{"en", "a", "a"},
+		{"en", "cat", "cat"},
+		{"en", "computer", "com put er"},
+		{"en", "Software", "Soft ware"},
+		{"en", "HYPHENATION", "HY PHEN A TION"},
+		{"en", "(hyphenation)", "(hy phen a tion)"},
+		{"en", "free-software", "free- soft ware"},
+		{"en", "ab-c", "ab- c"},
+		{"en", "-cat-", "-cat-"},
+		{"en", "xyzzy", "xyzzy"},
+		{"en", "qqqqqqqq", "qqqqqqqq"},
+		{"en", "x2y", "x2y"},
+		{"de", "computer", "com pu ter"},
+		{"de", "Fünfhundert", "Fünf hun dert"},
+		{"de", "Donaudampfschifffahrt", "Do nau dampf schiff fahrt"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.language+"/"+test.word, func(t *testing.T) {
+			h, ok := lookupHyphenator(test.language)
+			if !ok {
+				t.Fatalf("lookupHyphenator(%q) failed", test.language)
+			}
+
+			syllables := h.syllables(test.word)
+			if got := strings.Join(syllables, " "); got != test.want {
+				t.Errorf("syllables(%q) = %q, want %q", test.word, got, test.want)
+			}
+			if joined := strings.Join(syllables, ""); joined != test.word {
+				t.Errorf("syllables(%q) join to %q", test.word, joined)
+			}
+		})
+	}
+}
+
+func TestHyphenatorBreakpoints(t *testing.T) {
+	tests := []struct {
+		name     string
+		patterns string
+		word     string
+		want     []int
+	}{
+		{"no patterns", "", "abcdef", nil},
+		{"odd priority", "c1d", "abcdef", []int{3}},
+		{"even priority overrides", "c1d 2d", "abcdef", nil},
+		{"higher odd priority", "c2d 3d", "abcdef", []int{3}},
+		{"too far left", "a1b", "abcdef", nil},
+		{"too far right", "e1f", "abcdef", nil},
+		{"word boundary", ".ab1c", "abcdef", []int{2}},
+		{"no word boundary", ".b1c", "abcdef", nil},
+		{"case insensitive", "c1d", "ABCDEF", []int{3}},
+		{"multiple", "b1c d1e", "abcdefgh", []int{2, 4}},
+		{"short word", "a1b", "ab", nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := parseHyphenationPatterns(test.patterns)
+			if got := h.breakpoints([]rune(test.word)); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("breakpoints(%q) = %v, want %v", test.word, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the inline emphasis markup of the message, being
+// **bold**, *italic*, and ==accent== for the template's accent color.
+//
+// Within the layout, each styled character is followed by a style tag, a
+// private use character. Like a combining mark, the tag sticks to its base
+// character when words are split, hyphenated, or reordered.
+
+package main
+
+import (
+	"unicode"
+)
+
+// textStyle is a combination of the emphasis styles.
+type textStyle uint8
+
+const (
+	styleBold textStyle = 1 << iota
+	styleItalic
+	styleAccent
+)
+
+// styleTagBase is the style tag for no style, which is never used.
+const styleTagBase rune = '\uE000'
+
+// isStyleTag reports whether the rune is a style tag.
+func isStyleTag(r rune) bool {
+	return r > styleTagBase && r <= styleTagBase+rune(styleBold|styleItalic|styleAccent)
+}
+
+// isMark reports whether the rune belongs to its preceding base character,
+// either as a combining mark or a style tag.
+func isMark(r rune) bool {
+	return unicode.Is(unicode.Mn, r) || isStyleTag(r)
+}
+
+// markupDelimiters toggle a style, the longer ones being matched first.
+var markupDelimiters = []struct {
+	delim string
+	style textStyle
+}{
+	{"**", styleBold},
+	{"==", styleAccent},
+	{"*", styleItalic},
+}
+
+// parseMarkup of a message into its plain text and the style of each rune.
+//
+// A delimiter opens a style if followed by a non-space and closes it if preceded
+// by one. Delimiters without a partner and those escaped by a backslash are
+// kept as text. Style tags within the input are dropped.
+func parseMarkup(text string) (plain string, styles []textStyle) {
+	type token struct {
+		text  []rune
+		style textStyle
+	}
+
+	var tokens []token
+	runes := []rune(text)
+	for i := 0; i < len(runes); i++ {
+		if isStyleTag(runes[i]) {
+			continue
+		}
+
+		if runes[i] == '\\' && i+1 < len(runes) && (runes[i+1] == '*' || runes[i+1] == '=' || runes[i+1] == '\\') {
+			tokens = append(tokens, token{text: runes[i+1 : i+2]})
+			i++
+			continue
+		}
+
+		matched := false
+		for _, delimiter := range markupDelimiters {
+			delim := []rune(delimiter.delim)
+			if i+len(delim) <= len(runes) && string(runes[i:i+len(delim)]) == delimiter.delim {
+				tokens = append(tokens, token{text: delim, style: delimiter.style})
+				i += len(delim) - 1
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			tokens = append(tokens, token{text: runes[i : i+1]})
+		}
+	}
+
+	isSpaceAt := func(i int) bool {
+		return i < 0 || i >= len(tokens) || (tokens[i].style == 0 && unicode.IsSpace(tokens[i].text[0]))
+	}
+
+	// Pair the delimiters of each style, while unpaired ones become text.
+	paired := make([]bool, len(tokens))
+	for _, delimiter := range markupDelimiters {
+		open := -1
+		for i, t := range tokens {
+			if t.style != delimiter.style {
+				continue
+			}
+
+			if open >= 0 && !isSpaceAt(i-1) {
+				paired[open], paired[i] = true, true
+				open = -1
+			} else if !isSpaceAt(i + 1) {
+				open = i
+			}
+		}
+	}
+
+	var builder []rune
+	var style textStyle
+	for i, t := range tokens {
+		if t.style != 0 && paired[i] {
+			style ^= t.style
+			continue
+		}
+
+		for _, r := range t.text {
+			builder = append(builder, r)
+			styles = append(styles, style)
+		}
+	}
+	return string(builder), styles
+}
+
+// markStyles of the runes by appending a style tag to each styled one. Spaces
+// are never tagged, as their style is invisible.
+func markStyles(runes []rune, styles []textStyle) string {
+	marked := make([]rune, 0, len(runes))
+	for i, r := range runes {
+		marked = append(marked, r)
+		if styles[i] != 0 && !unicode.IsSpace(r) {
+			marked = append(marked, styleTagBase+rune(styles[i]))
+		}
+	}
+	return string(marked)
+}
+
+// unmarkStyles of a text with style tags, resulting in its runes and their styles.
+func unmarkStyles(text string) (runes []rune, styles []textStyle) {
+	for _, r := range text {
+		if isStyleTag(r) {
+			if len(styles) > 0 {
+				styles[len(styles)-1] = textStyle(r - styleTagBase)
+			}
+			continue
+		}
+		runes = append(runes, r)
+		styles = append(styles, 0)
+	}
+	return
+}
+
+// styleWords by tagging each word's runes with the styles of the plain text,
+// which the words were split from.
+func styleWords(words []string, styles []textStyle) []string {
+	styled := make([]string, len(words))
+	offset := 0
+	for i, word := range words {
+		runes := []rune(word)
+		if offset+len(runes) > len(styles) {
+			styled[i] = word
+			continue
+		}
+		styled[i] = markStyles(runes, styles[offset:offset+len(runes)])
+		offset += len(runes)
+	}
+	return styled
+}
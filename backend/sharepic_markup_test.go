@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the inline emphasis markup.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// formatStyles as one digit per rune, being the sum of 1 for bold, 2 for
+// italic, and 4 for accent.
+func formatStyles(styles []textStyle) string {
+	var builder strings.Builder
+	for _, style := range styles {
+		builder.WriteByte('0' + byte(style))
+	}
+	return builder.String()
+}
+
+func TestParseMarkup(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		plain  string
+		styles string
+	}{
+		{"empty", "", "", ""},
+		{"plain", "plain", "plain", "00000"},
+		{"bold", "**bold** text", "bold text", "111100000"},
+		{"italic", "*it*", "it", "22"},
+		{"accent", "==acc==", "acc", "444"},
+		{"bold and italic", "***both***", "both", "3333"},
+		{"nested", "**a *b* c**", "a b c", "11311"},
+		{"nested accent", "==a **b**==", "a b", "445"},
+		{"unclosed", "**bold", "**bold", "000000"},
+		{"unopened", "bold**", "bold**", "000000"},
+		{"unclosed within closed", "*a **b*", "a **b", "22222"},
+		{"surrounded by spaces", "2 * 3 = 6", "2 * 3 = 6", "000000000"},
+		{"spaces inside", "a ** b ** c", "a ** b ** c", "00000000000"},
+		{"escaped", `\*not\*`, "*not*", "00000"},
+		{"escaped backslash", `\\*it*`, `\it`, "022"},
+		{"style tags dropped", "a\ue001b", "ab", "00"},
+		{"right-to-left", "**שלום**", "שלום", "1111"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			plain, styles := parseMarkup(test.text)
+			if plain != test.plain || formatStyles(styles) != test.styles {
+				t.Errorf("parseMarkup(%q) = %q, %s, want %q, %s", test.text, plain, formatStyles(styles), test.plain, test.styles)
+			}
+		})
+	}
+}
+
+func TestMarkStyles(t *testing.T) {
+	tests := []struct {
+		text   string
+		styles []textStyle
+		want   string
+	}{
+		{"", nil, ""},
+		{"ab", []textStyle{0, 0}, "ab"},
+		{"a b", []textStyle{styleBold, styleBold, styleBold}, "a\ue001 b\ue001"},
+		{"ab", []textStyle{styleItalic | styleAccent, 0}, "a\ue006b"},
+	}
+
+	for _, test := range tests {
+		marked := markStyles([]rune(test.text), test.styles)
+		if marked != test.want {
+			t.Errorf("markStyles(%q, %v) = %q, want %q", test.text, test.styles, marked, test.want)
+		}
+
+		// Spaces are never marked, but take the style of their tag-less input.
+		runes, styles := unmarkStyles(marked)
+		for i := range styles {
+			if string(runes[i]) == " " {
+				styles[i] = test.styles[i]
+			}
+		}
+		if string(runes) != test.text || formatStyles(styles) != formatStyles(test.styles) {
+			t.Errorf("unmarkStyles(%q) = %q, %s, want %q, %s", marked, string(runes), formatStyles(styles), test.text, formatStyles(test.styles))
+		}
+	}
+}
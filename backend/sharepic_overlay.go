@@ -14,33 +14,85 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
 	"strings"
 	"sync"
+	"unicode"
+	"unicode/utf8"
 
+	"github.com/rivo/uniseg"
 	"gopkg.in/gographics/imagick.v3/imagick"
 )
 
 // heightOverflowErr if the height exceeds the box's height.
 var heightOverflowErr = fmt.Errorf("box height overflows")
 
-// conjureWordDimensions calculates the rendered length of all word subsets.
-//
-// This function determines the length of all substrings of the words array,
-// beginning from only the first word to all words. The two dimensional dims
-// array will contain an array of the length of words, each value being itself
-// an array - containing the width and height.
-func conjureWordDimensions(ctx context.Context, font string, size int, words []string) (dims [][]int, err error) {
-	if len(words) == 0 {
-		return nil, fmt.Errorf("cannot work on an empty words array")
+// Line breaking algorithms, see conjureGreedyWrap and conjureBalancedWrap.
+const (
+	lineBreakingGreedy   = "greedy"
+	lineBreakingBalanced = "balanced"
+)
+
+// boxOptions describes the box a text should be fitted in.
+type boxOptions struct {
+	// Fonts for each style of the text, see textStyle.
+	Fonts fontFamily
+
+	Width  int
+	Height int
+
+	// Balanced selects balanced line breaking over greedily filled lines.
+	Balanced bool
+
+	// Language of the text to hyphenate words being wider than the box.
+	// Hyphenation is disabled for an empty language.
+	Language string
+}
+
+// runMetrics are the dimensions of runs drawn next to each other: the width of
+// each run, the height of the highest run, and the biggest ascender resp.
+// descender of all fonts, both being positive.
+type runMetrics struct {
+	Widths  []float64
+	Height  float64
+	Ascent  float64
+	Descent float64
+}
+
+// Width of all runs together.
+func (metrics runMetrics) Width() (width float64) {
+	for _, runWidth := range metrics.Widths {
+		width += runWidth
 	}
+	return
+}
 
+// measureRuns of a text, split by splitFontRuns, as drawn next to each other.
+func measureRuns(mw *imagick.MagickWand, dw *imagick.DrawingWand, runs []textRun) (metrics runMetrics, err error) {
+	metrics.Widths = make([]float64, len(runs))
+	for i, run := range runs {
+		if err = dw.SetFont(run.Font); err != nil {
+			return
+		}
+
+		fm := mw.QueryFontMetrics(dw, run.Text)
+		metrics.Widths[i] = fm.TextWidth
+		metrics.Height = math.Max(metrics.Height, fm.TextHeight)
+		metrics.Ascent = math.Max(metrics.Ascent, fm.Ascender)
+		metrics.Descent = math.Max(metrics.Descent, -fm.Descender)
+	}
+	return
+}
+
+// conjureTextDimensions calculates the rendered width and height of each text.
+func conjureTextDimensions(ctx context.Context, fonts fontFamily, size int, texts []string) (dims [][]int, err error) {
 	var dimsMutex, errMutex sync.Mutex
-	dims = make([][]int, len(words))
+	dims = make([][]int, len(texts))
 
 	var wg sync.WaitGroup
-	wg.Add(len(words))
+	wg.Add(len(texts))
 
-	for i := range words {
+	for i := range texts {
 		go func(i int) {
 			defer wg.Done()
 
@@ -66,15 +118,14 @@ func conjureWordDimensions(ctx context.Context, font string, size int, words []s
 			}
 
 			dw.SetFontSize(float64(size))
-			if tmpErr = dw.SetFont(font); tmpErr != nil {
+			var metrics runMetrics
+			if metrics, tmpErr = measureRuns(mw, dw, splitFontRuns(fonts, texts[i])); tmpErr != nil {
 				return
 			}
 
-			fm := mw.QueryFontMetrics(dw, strings.Join(words[:i+1], " "))
-
 			dimsMutex.Lock()
 			defer dimsMutex.Unlock()
-			dims[i] = []int{int(fm.TextWidth), int(fm.TextHeight)}
+			dims[i] = []int{int(metrics.Width()), int(metrics.Height)}
 		}(i)
 	}
 
@@ -83,13 +134,234 @@ func conjureWordDimensions(ctx context.Context, font string, size int, words []s
 	return
 }
 
+// conjureWordDimensions calculates the rendered length of all word subsets.
+//
+// This function determines the length of all substrings of the words array,
+// beginning from only the first word to all words. The two dimensional dims
+// array will contain an array of the length of words, each value being itself
+// an array - containing the width and height.
+func conjureWordDimensions(ctx context.Context, fonts fontFamily, size int, words []string) (dims [][]int, err error) {
+	if len(words) == 0 {
+		return nil, fmt.Errorf("cannot work on an empty words array")
+	}
+
+	prefixes := make([]string, len(words))
+	for i := range words {
+		prefixes[i] = joinWords(words[:i+1])
+	}
+
+	return conjureTextDimensions(ctx, fonts, size, prefixes)
+}
+
+// boxLayout is the arrangement of a text within a box: its lines, each line's
+// rendered width, the font size, and the height of each line.
+type boxLayout struct {
+	Sentences  [][]string
+	Widths     []int
+	Size       int
+	LineHeight int
+}
+
+// Height of all lines together.
+func (layout boxLayout) Height() int {
+	return len(layout.Sentences) * layout.LineHeight
+}
+
+// Text alignments within a box, both horizontal and vertical.
+const (
+	alignLeft   = "left"
+	alignCenter = "center"
+	alignRight  = "right"
+
+	alignTop    = "top"
+	alignMiddle = "middle"
+	alignBottom = "bottom"
+)
+
+// lineOffsets calculates the position of each line's baseline relative to the
+// box's top-left corner for the given alignments. Empty alignments are left
+// resp. top.
+func (layout boxLayout) lineOffsets(boxWidth, boxHeight int, align, verticalAlign string) (xs, ys []int) {
+	offsetY := 0
+	switch verticalAlign {
+	case alignMiddle:
+		offsetY = (boxHeight - layout.Height()) / 2
+	case alignBottom:
+		offsetY = boxHeight - layout.Height()
+	}
+
+	xs, ys = make([]int, len(layout.Sentences)), make([]int, len(layout.Sentences))
+	for i := range layout.Sentences {
+		switch align {
+		case alignCenter:
+			xs[i] = (boxWidth - layout.Widths[i]) / 2
+		case alignRight:
+			xs[i] = boxWidth - layout.Widths[i]
+		}
+		ys[i] = offsetY + (i+1)*layout.LineHeight
+	}
+	return
+}
+
+// hardBreak is a pseudo word forcing a line break. Two consecutive hard breaks
+// result in an empty line, separating paragraphs.
+const hardBreak = "\n"
+
+// splitWords of a text at its line break opportunities, as defined by the
+// Unicode Line Breaking Algorithm (UAX #14). Thus, texts without spaces, e.g.,
+// Chinese or Japanese, are split as well. Each word keeps its trailing spaces,
+// while newlines are kept as hardBreak words.
+func splitWords(text string) (words []string) {
+	for i, line := range strings.Split(text, "\n") {
+		if i > 0 {
+			words = append(words, hardBreak)
+		}
+
+		state := -1
+		for line != "" {
+			var word string
+			word, line, _, state = uniseg.FirstLineSegmentInString(line, state)
+			words = append(words, word)
+		}
+	}
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	return
+}
+
+// joinWords to the text of a line, dropping the last word's trailing spaces.
+func joinWords(words []string) string {
+	return strings.TrimRightFunc(strings.Join(words, ""), unicode.IsSpace)
+}
+
+// trimWords returns the words without their trailing spaces.
+func trimWords(words []string) []string {
+	trimmed := make([]string, len(words))
+	for i, word := range words {
+		trimmed[i] = strings.TrimRightFunc(word, unicode.IsSpace)
+	}
+	return trimmed
+}
+
 // conjureBoxWrap creates sentences of the words for the font within a box.
 //
 // By utilizing the conjureWordDimensions function, sentences are being built
-// to be rendered as text not exceeding the box's borders. If it is not possible
-// to create sentences within the given constraints, the heightOverflow error is
-// returned.
-func conjureBoxWrap(ctx context.Context, font string, size int, words []string, boxWidth, boxHeight int) (sentences [][]string, lineHeight int, err error) {
+// to be rendered as text not exceeding the box's borders. A hardBreak word ends
+// the current sentence. If it is not possible to create sentences within the
+// given constraints, the heightOverflow error is returned.
+func conjureBoxWrap(ctx context.Context, opts boxOptions, size int, words []string) (layout boxLayout, err error) {
+	layout.Size = size
+
+	// Wrap each part between hard breaks on its own. An empty part results in
+	// an empty line, except after the last part.
+	var part []string
+	for i, word := range words {
+		if word != hardBreak {
+			part = append(part, word)
+			if i < len(words)-1 {
+				continue
+			}
+		}
+
+		if len(part) == 0 {
+			layout.Sentences = append(layout.Sentences, nil)
+			layout.Widths = append(layout.Widths, 0)
+		} else if err = conjurePartWrap(ctx, opts, size, part, &layout); err != nil {
+			return boxLayout{}, err
+		}
+		part = nil
+	}
+
+	// Empty lines were not considered for the height before.
+	if layout.Height() > opts.Height {
+		return boxLayout{}, heightOverflowErr
+	}
+
+	return
+}
+
+// conjurePartWrap appends the sentences for words without hard breaks to the
+// layout, as described for conjureBoxWrap.
+func conjurePartWrap(ctx context.Context, opts boxOptions, size int, words []string, layout *boxLayout) error {
+	if opts.Language != "" {
+		var err error
+		if words, err = hyphenateWideWords(ctx, opts, size, words); err != nil {
+			return err
+		}
+	}
+
+	if opts.Balanced {
+		return conjureBalancedWrap(ctx, opts, size, words, layout)
+	}
+	return conjureGreedyWrap(ctx, opts, size, words, layout)
+}
+
+// hyphenateWideWords splits each word being wider than the box into parts, as
+// few as possible and each fitting into a line on its own, as far as the
+// hyphenation patterns of the box's language allow.
+func hyphenateWideWords(ctx context.Context, opts boxOptions, size int, words []string) ([]string, error) {
+	h, ok := lookupHyphenator(opts.Language)
+	if !ok {
+		return nil, fmt.Errorf("no hyphenation patterns for language %q", opts.Language)
+	}
+
+	dims, err := conjureTextDimensions(ctx, opts.Fonts, size, trimWords(words))
+	if err != nil {
+		return nil, fmt.Errorf("cannot conjure dimensions, %w", err)
+	}
+
+	result := make([]string, 0, len(words))
+	for i, word := range words {
+		if dims[i][0] < opts.Width {
+			result = append(result, word)
+			continue
+		}
+
+		// The syllables are split from the plain word, while each part is tagged
+		// with its styles again. An added hyphen has the style of its letter.
+		runes, styles := unmarkStyles(word)
+		for syllables := h.syllables(string(runes)); len(syllables) > 0; {
+			parts := make([]string, len(syllables))
+			lengths := make([]int, len(syllables))
+			for j := range syllables {
+				lengths[j] = utf8.RuneCountInString(syllables[j])
+				if j > 0 {
+					lengths[j] += lengths[j-1]
+				}
+
+				partRunes := append([]rune{}, runes[:lengths[j]]...)
+				partStyles := append([]textStyle{}, styles[:lengths[j]]...)
+				if j < len(syllables)-1 && !strings.HasSuffix(syllables[j], hyphen) {
+					for _, r := range hyphen {
+						partRunes = append(partRunes, r)
+						partStyles = append(partStyles, styles[lengths[j]-1])
+					}
+				}
+				parts[j] = markStyles(partRunes, partStyles)
+			}
+
+			partDims, err := conjureTextDimensions(ctx, opts.Fonts, size, parts)
+			if err != nil {
+				return nil, fmt.Errorf("cannot conjure dimensions, %w", err)
+			}
+
+			// The first part is taken even if too wide, failing later on.
+			j := 0
+			for j < len(parts)-1 && partDims[j+1][0] < opts.Width {
+				j++
+			}
+			result = append(result, parts[j])
+			runes, styles = runes[lengths[j]:], styles[lengths[j]:]
+			syllables = syllables[j+1:]
+		}
+	}
+	return result, nil
+}
+
+// conjureGreedyWrap appends the sentences for words without hard breaks to the
+// layout, filling each line with as many words as possible.
+func conjureGreedyWrap(ctx context.Context, opts boxOptions, size int, words []string, layout *boxLayout) error {
 	min := func(x, y int) int {
 		if x > y {
 			return y
@@ -97,62 +369,202 @@ func conjureBoxWrap(ctx context.Context, font string, size int, words []string,
 		return x
 	}
 
-	height := 0
+	height := layout.Height()
 
 	for len(words) > 0 {
-		// Limit to a maximum of eight words as this is sufficient for our use case.
-		lineOpts, lineOptsErr := conjureWordDimensions(ctx, font, size, words[:min(len(words), 8)])
-		if lineOptsErr != nil {
-			return nil, 0, fmt.Errorf("cannot conjure dimensions, %w", lineOptsErr)
+		// Measure a growing prefix of the words until its longest option exceeds
+		// the box width. Doubling the prefix limits the measurements to about
+		// twice the words fitting into the line.
+		var lineOpts [][]int
+		for prefix := min(len(words), 8); ; prefix = min(len(words), 2*prefix) {
+			var lineOptsErr error
+			lineOpts, lineOptsErr = conjureWordDimensions(ctx, opts.Fonts, size, words[:prefix])
+			if lineOptsErr != nil {
+				return fmt.Errorf("cannot conjure dimensions, %w", lineOptsErr)
+			}
+
+			if prefix == len(words) || lineOpts[prefix-1][0] >= opts.Width {
+				break
+			}
 		}
 
 		var i int
-		for i = 0; i < len(lineOpts) && lineOpts[i][0] < boxWidth; i++ {
+		for i = 0; i < len(lineOpts) && lineOpts[i][0] < opts.Width; i++ {
 		}
 		i--
 
 		if i < 0 {
-			return nil, 0, fmt.Errorf("cannot find line options fitting box width for '%v'", words)
+			return fmt.Errorf("cannot find line options fitting box width for '%v'", words)
 		}
 
 		// +1 as lineOpts with index 0 represents one word and [:0] would be the
 		// empty slice. Generalized, index i represents i+1 words.
-		sentences = append(sentences, words[:i+1])
+		layout.Sentences = append(layout.Sentences, words[:i+1])
+		layout.Widths = append(layout.Widths, lineOpts[i][0])
 		words = words[i+1:]
 
 		// Multiply with 1.1 for some margin between the text; highly opinionated.
-		lineHeight = int(1.1 * float64(lineOpts[i][1]))
+		layout.LineHeight = int(1.1 * float64(lineOpts[i][1]))
 
-		height += lineHeight
-		if height > boxHeight {
-			return nil, 0, heightOverflowErr
+		height += layout.LineHeight
+		if height > opts.Height {
+			return heightOverflowErr
 		}
 	}
 
-	return
+	return nil
+}
+
+// conjureBalancedWrap appends the sentences for words without hard breaks to
+// the layout, minimizing the raggedness of the lines.
+//
+// Based on the widths of the single words and the average width of a space,
+// derived from all words as a single line, the line breaks are chosen
+// by dynamic programming. Only the least amount of lines is considered. Within
+// those, the sum of squared free space of all but the last line is minimized,
+// while a last line with a single word is penalized. If the actual rendered
+// width of a line exceeds the box, conjureGreedyWrap is used instead.
+func conjureBalancedWrap(ctx context.Context, opts boxOptions, size int, words []string, layout *boxLayout) error {
+	n := len(words)
+
+	wordDims, err := conjureTextDimensions(ctx, opts.Fonts, size, trimWords(words))
+	if err != nil {
+		return fmt.Errorf("cannot conjure dimensions, %w", err)
+	}
+
+	// prefixWidths[i] is the sum of the widths of words[:i], while
+	// prefixSpaces[i] counts the words in words[:i] followed by a space.
+	prefixWidths, prefixSpaces := make([]float64, n+1), make([]int, n+1)
+	for i, word := range words {
+		if wordDims[i][0] >= opts.Width {
+			return fmt.Errorf("cannot find line options fitting box width for '%v'", words[i:])
+		}
+		prefixWidths[i+1] = prefixWidths[i] + float64(wordDims[i][0])
+
+		prefixSpaces[i+1] = prefixSpaces[i]
+		if strings.TrimRightFunc(word, unicode.IsSpace) != word {
+			prefixSpaces[i+1]++
+		}
+	}
+
+	// The space's width results from all words as a single line, being the
+	// words' widths and the spaces between them.
+	var space float64
+	if prefixSpaces[n-1] > 0 {
+		lineDims, err := conjureTextDimensions(ctx, opts.Fonts, size, []string{joinWords(words)})
+		if err != nil {
+			return fmt.Errorf("cannot conjure dimensions, %w", err)
+		}
+		space = math.Max(0, float64(lineDims[0][0])-prefixWidths[n]) / float64(prefixSpaces[n-1])
+	}
+
+	// lineWidth of the words[i:j] as a line, without the last word's spaces.
+	lineWidth := func(i, j int) float64 {
+		return prefixWidths[j] - prefixWidths[i] + float64(prefixSpaces[j-1]-prefixSpaces[i])*space
+	}
+	fits := func(i, j int) bool {
+		return lineWidth(i, j) < float64(opts.Width)
+	}
+	lineCost := func(i, j int) float64 {
+		if j == n {
+			if j-i == 1 && n > 1 {
+				return float64(opts.Width * opts.Width)
+			}
+			return 0
+		}
+		slack := float64(opts.Width) - lineWidth(i, j)
+		return slack * slack
+	}
+
+	// The least amount of lines results from greedily filled lines.
+	lines := 0
+	for i := 0; i < n; lines++ {
+		j := i + 1
+		for j < n && fits(i, j+1) {
+			j++
+		}
+		i = j
+	}
+
+	// costs[k][j] is the minimal cost of words[:j] in k lines, while starts[k][j]
+	// is the index of the first word of the k-th line for this cost.
+	costs, starts := make([][]float64, lines+1), make([][]int, lines+1)
+	for k := range costs {
+		costs[k], starts[k] = make([]float64, n+1), make([]int, n+1)
+		for j := range costs[k] {
+			costs[k][j] = math.Inf(1)
+		}
+	}
+	costs[0][0] = 0
+
+	for k := 1; k <= lines; k++ {
+		for j := k; j <= n; j++ {
+			for i := j - 1; i >= k-1 && fits(i, j); i-- {
+				if cost := costs[k-1][i] + lineCost(i, j); cost < costs[k][j] {
+					costs[k][j], starts[k][j] = cost, i
+				}
+			}
+		}
+	}
+
+	if math.IsInf(costs[lines][n], 1) {
+		return conjureGreedyWrap(ctx, opts, size, words, layout)
+	}
+
+	sentences := make([][]string, lines)
+	for k, j := lines, n; k > 0; k-- {
+		i := starts[k][j]
+		sentences[k-1] = words[i:j]
+		j = i
+	}
+
+	texts := make([]string, lines)
+	for i, sentence := range sentences {
+		texts[i] = joinWords(sentence)
+	}
+	lineDims, err := conjureTextDimensions(ctx, opts.Fonts, size, texts)
+	if err != nil {
+		return fmt.Errorf("cannot conjure dimensions, %w", err)
+	}
+	for _, dims := range lineDims {
+		if dims[0] >= opts.Width {
+			return conjureGreedyWrap(ctx, opts, size, words, layout)
+		}
+	}
+
+	for i, sentence := range sentences {
+		layout.Sentences = append(layout.Sentences, sentence)
+		layout.Widths = append(layout.Widths, lineDims[i][0])
+
+		// Multiply with 1.1 for some margin between the text; highly opinionated.
+		layout.LineHeight = int(1.1 * float64(lineDims[i][1]))
+	}
+
+	if layout.Height() > opts.Height {
+		return heightOverflowErr
+	}
+	return nil
 }
 
 // ConjureBox calculates the optimal sentences and font size.
 //
 // This is achieved by a parallel execution of the conjureBoxWrap function with
 // varying font sizes. Eventually, the "biggest" results will be used.
-func ConjureBox(ctx context.Context, font string, sizes []int, words []string, boxWidth, boxHeight int) (sentences [][]string, size, lineHeight int, err error) {
+func ConjureBox(ctx context.Context, opts boxOptions, sizes []int, words []string) (layout boxLayout, err error) {
 	resolutionChan := make(chan struct {
-		sentences  [][]string
-		size       int
-		lineHeight int
-		err        error
+		layout boxLayout
+		size   int
+		err    error
 	})
 
 	for _, testSize := range sizes {
 		go func(size int) {
-			sentences, lineHeight, err := conjureBoxWrap(ctx, font, size, words, boxWidth, boxHeight)
+			layout, err := conjureBoxWrap(ctx, opts, size, words)
 			resolutionChan <- struct {
-				sentences  [][]string
-				size       int
-				lineHeight int
-				err        error
-			}{sentences, size, lineHeight, err}
+				layout boxLayout
+				size   int
+				err    error
+			}{layout, size, err}
 		}(testSize)
 	}
 
@@ -162,18 +574,65 @@ func ConjureBox(ctx context.Context, font string, sizes []int, words []string, b
 		if resolution.err != nil && !errors.Is(resolution.err, heightOverflowErr) {
 			log.Printf("size %d resulted in unexpected error, %v", resolution.size, resolution.err)
 		}
-		if resolution.err != nil || resolution.size <= size {
+		if resolution.err != nil || resolution.size <= layout.Size {
 			continue
 		}
 
-		sentences = resolution.sentences
-		lineHeight = resolution.lineHeight
-		size = resolution.size
+		layout = resolution.layout
 	}
 
-	if size <= 0 {
-		return nil, 0, 0, fmt.Errorf("cannot select a fitting font size for input")
+	if layout.Size <= 0 {
+		return boxLayout{}, fmt.Errorf("cannot select a fitting font size for input")
 	}
 
 	return
 }
+
+// rangeProbes is the amount of font sizes tried in parallel per iteration of
+// ConjureBoxRange.
+const rangeProbes = 4
+
+// ConjureBoxRange calculates the optimal sentences and the biggest font size
+// within a range of font sizes.
+//
+// Assuming that a text fitting for one size also fits for all smaller sizes,
+// the range is narrowed down by probing evenly spaced sizes in parallel by the
+// ConjureBox function, similar to a bisection.
+func ConjureBoxRange(ctx context.Context, opts boxOptions, minSize, maxSize int, words []string) (layout boxLayout, err error) {
+	low, high := minSize, maxSize
+	for low <= high {
+		if err = ctx.Err(); err != nil {
+			return boxLayout{}, err
+		}
+
+		var sizes []int
+		for i := 0; i < rangeProbes; i++ {
+			size := low + (high-low)*i/(rangeProbes-1)
+			if len(sizes) == 0 || sizes[len(sizes)-1] != size {
+				sizes = append(sizes, size)
+			}
+		}
+
+		probe, probeErr := ConjureBox(ctx, opts, sizes, words)
+		if probeErr != nil {
+			// Not even the smallest probed size fits.
+			high = sizes[0] - 1
+			continue
+		}
+
+		layout = probe
+		low = probe.Size + 1
+		for _, size := range sizes {
+			if size > probe.Size {
+				high = size - 1
+				break
+			}
+		}
+	}
+
+	if layout.Size <= 0 {
+		return boxLayout{}, fmt.Errorf("cannot select a fitting font size for input")
+	}
+
+	return layout, nil
+}
@@ -0,0 +1,207 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the fit modes and masks of a picture box, applied while
+// preparing a picture, as well as the fill replacing a missing picture.
+//
+// Besides the default cover mode, cropping the picture to the box, a picture
+// might be letterboxed by the contain and blur modes. Afterwards, a circle or
+// rounded rectangle mask might be applied.
+
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"gopkg.in/gographics/imagick.v3/imagick"
+)
+
+// Fit modes of a picture box, see pictureBoxConf.
+const (
+	fitCover   = "cover"
+	fitContain = "contain"
+	fitBlur    = "blur"
+)
+
+// Masks of a picture box, see pictureBoxConf.
+const (
+	maskCircle  = "circle"
+	maskRounded = "rounded"
+)
+
+// blurFitSigma of the blurred background for the blur fit mode, based on the
+// prepared picture's size being four times the box.
+const blurFitSigma = 24.0
+
+// newFillWand creates an image of the given size, being either a solid color or
+// a vertical gradient.
+//
+// As the policy.xml denies pseudo-image coders like xc: and gradient:, the
+// gradient is drawn row by row instead.
+func newFillWand(fill fillConf, width, height uint) (*imagick.MagickWand, error) {
+	fromPw, err := newPixelWand(fill.Color, "fill's")
+	if err != nil {
+		return nil, err
+	}
+
+	mw := imagick.NewMagickWand()
+	if fill.GradientTo == "" {
+		if err := mw.NewImage(width, height, fromPw); err != nil {
+			return nil, fmt.Errorf("cannot create fill, %w", err)
+		}
+		return mw, nil
+	}
+
+	toPw, err := newPixelWand(fill.GradientTo, "fill's gradient")
+	if err != nil {
+		return nil, err
+	}
+	transparentPw, err := newPixelWand("none", "fill's")
+	if err != nil {
+		return nil, err
+	}
+	if err := mw.NewImage(width, height, transparentPw); err != nil {
+		return nil, fmt.Errorf("cannot create fill, %w", err)
+	}
+
+	// Without antialiasing, each row is filled exactly by its color.
+	dw := imagick.NewDrawingWand()
+	dw.SetStrokeAntialias(false)
+
+	rowPw := imagick.NewPixelWand()
+	lerp := func(from, to, t float64) float64 {
+		return from + (to-from)*t
+	}
+	for y := uint(0); y < height; y++ {
+		var t float64
+		if height > 1 {
+			t = float64(y) / float64(height-1)
+		}
+
+		rowPw.SetRed(lerp(fromPw.GetRed(), toPw.GetRed(), t))
+		rowPw.SetGreen(lerp(fromPw.GetGreen(), toPw.GetGreen(), t))
+		rowPw.SetBlue(lerp(fromPw.GetBlue(), toPw.GetBlue(), t))
+		rowPw.SetAlpha(lerp(fromPw.GetAlpha(), toPw.GetAlpha(), t))
+
+		dw.SetFillColor(rowPw)
+		dw.Rectangle(0, float64(y), float64(width)-1, float64(y))
+	}
+	if err := mw.DrawImage(dw); err != nil {
+		return nil, fmt.Errorf("cannot create fill, %w", err)
+	}
+	return mw, nil
+}
+
+// fillPicture creates a picture for the box from its fill, the returned byte
+// slice contains a JPEG image, or a PNG image for a masked box.
+func fillPicture(box pictureBoxConf) (picData []byte, err error) {
+	mw, err := newFillWand(box.Fill, 4*uint(box.Width), 4*uint(box.Height))
+	if err != nil {
+		return
+	}
+
+	if err = mw.SetImageFormat("JPEG"); err != nil {
+		return
+	}
+
+	if box.Mask != "" {
+		if err = maskPicture(mw, box); err != nil {
+			return
+		}
+	}
+
+	picData = mw.GetImageBlob()
+	return
+}
+
+// containPicture scales the whole picture into the wished size, letterboxed by
+// either the box's background or a blurred and enlarged copy of the picture.
+func containPicture(mw *imagick.MagickWand, box pictureBoxConf, wishWidth, wishHeight float64) (*imagick.MagickWand, error) {
+	baseWidth, baseHeight := float64(mw.GetImageWidth()), float64(mw.GetImageHeight())
+
+	var background *imagick.MagickWand
+	if box.Fit == fitBlur {
+		background = mw.Clone()
+
+		cropWidth, cropHeight, cropX, cropY := defaultPictureCrop.region(baseWidth, baseHeight, wishWidth, wishHeight)
+		if err := background.CropImage(cropWidth, cropHeight, cropX, cropY); err != nil {
+			return nil, err
+		}
+		if err := background.SetImagePage(0, 0, 0, 0); err != nil {
+			return nil, err
+		}
+		if err := background.ResizeImage(uint(wishWidth), uint(wishHeight), imagick.FILTER_GAUSSIAN); err != nil {
+			return nil, err
+		}
+		if err := background.GaussianBlurImage(0, blurFitSigma); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if background, err = newFillWand(box.Background, uint(wishWidth), uint(wishHeight)); err != nil {
+			return nil, err
+		}
+	}
+
+	scale := math.Min(wishWidth/baseWidth, wishHeight/baseHeight)
+	fitWidth, fitHeight := math.Max(1, math.Round(baseWidth*scale)), math.Max(1, math.Round(baseHeight*scale))
+	if err := mw.ResizeImage(uint(fitWidth), uint(fitHeight), imagick.FILTER_GAUSSIAN); err != nil {
+		return nil, err
+	}
+
+	if err := background.CompositeImageGravity(mw, imagick.COMPOSITE_OP_OVER, imagick.GRAVITY_CENTER); err != nil {
+		return nil, err
+	}
+	if err := background.SetImageFormat("JPEG"); err != nil {
+		return nil, err
+	}
+	return background, nil
+}
+
+// maskPicture by the box's mask, making the outside transparent. As JPEG lacks
+// transparency, the picture is converted to PNG.
+func maskPicture(mw *imagick.MagickWand, box pictureBoxConf) error {
+	transparentPw, err := newPixelWand("none", "mask's")
+	if err != nil {
+		return err
+	}
+	opaquePw, err := newPixelWand("white", "mask's")
+	if err != nil {
+		return err
+	}
+
+	width, height := mw.GetImageWidth(), mw.GetImageHeight()
+
+	mask := imagick.NewMagickWand()
+	if err = mask.NewImage(width, height, transparentPw); err != nil {
+		return err
+	}
+
+	dw := imagick.NewDrawingWand()
+	dw.SetFillColor(opaquePw)
+
+	// The radius is given in the box's size, while the picture is four times as
+	// large.
+	right, bottom := float64(width)-1, float64(height)-1
+	switch box.Mask {
+	case maskCircle:
+		dw.Ellipse(right/2, bottom/2, right/2, bottom/2, 0, 360)
+	case maskRounded:
+		dw.RoundRectangle(0, 0, right, bottom, 4*box.Radius, 4*box.Radius)
+	default:
+		return fmt.Errorf("unsupported mask %q", box.Mask)
+	}
+	if err = mask.DrawImage(dw); err != nil {
+		return err
+	}
+
+	if err = mw.SetImageFormat("PNG"); err != nil {
+		return err
+	}
+	if err = mw.SetImageAlphaChannel(imagick.ALPHA_CHANNEL_SET); err != nil {
+		return err
+	}
+	return mw.CompositeImage(mask, imagick.COMPOSITE_OP_DST_IN, true, 0, 0)
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of a picture box's fit modes, masks, and fill.
+
+package main
+
+import (
+	"testing"
+)
+
+// TestPreparePictureFill checks that a missing optional picture is replaced by
+// its vertical gradient fill.
+func TestPreparePictureFill(t *testing.T) {
+	box := pictureBoxConf{
+		Width:    50,
+		Height:   50,
+		Optional: true,
+		Fill:     fillConf{Color: "red", GradientTo: "blue"},
+	}
+
+	picData, err := preparePicture(nil, box, defaultPictureCrop)
+	if err != nil {
+		t.Fatalf("cannot prepare fill, %v", err)
+	}
+
+	mw := readPicture(t, picData, 200, 200)
+	probes := []struct {
+		y     int
+		color string
+	}{
+		{5, "red"},
+		{195, "blue"},
+	}
+	for _, probe := range probes {
+		if color := pixelColor(t, mw, 100, probe.y); color != probe.color {
+			t.Errorf("fill has %q instead of %q at 100,%d", color, probe.color, probe.y)
+		}
+	}
+}
+
+// TestPrepareInputImageFit checks that the contain and blur fit modes letterbox
+// a picture on their backgrounds and that the circle mask makes the corners
+// transparent, all being created under the policy.xml.
+func TestPrepareInputImageFit(t *testing.T) {
+	type probe struct {
+		x, y  int
+		color string
+	}
+
+	// The 300x200 fixture is scaled to 200x133, starting at y=33.
+	tests := []struct {
+		name   string
+		box    pictureBoxConf
+		probes []probe
+	}{
+		{
+			name: "contain with mask",
+			box: pictureBoxConf{
+				Fit:        fitContain,
+				Background: fillConf{Color: "lime"},
+				Mask:       maskCircle,
+			},
+			probes: []probe{{2, 2, "transparent"}, {100, 15, "lime"}, {60, 60, "red"}, {140, 140, "blue"}},
+		},
+		{
+			name: "contain with gradient",
+			box: pictureBoxConf{
+				Fit:        fitContain,
+				Background: fillConf{Color: "lime", GradientTo: "blue"},
+			},
+			probes: []probe{{100, 10, "lime"}, {100, 190, "blue"}, {60, 60, "red"}, {140, 140, "blue"}},
+		},
+		{
+			name:   "blur",
+			box:    pictureBoxConf{Fit: fitBlur},
+			probes: []probe{{20, 15, "red"}, {180, 15, "blue"}, {60, 60, "red"}, {140, 140, "blue"}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.box.Width, test.box.Height = 50, 50
+
+			picData, err := prepareInputImage(orientationFixture(t, 1), test.box, defaultPictureCrop)
+			if err != nil {
+				t.Fatalf("cannot prepare picture, %v", err)
+			}
+
+			mw := readPicture(t, picData, 200, 200)
+			for _, probe := range test.probes {
+				if color := pixelColor(t, mw, probe.x, probe.y); color != probe.color {
+					t.Errorf("picture has %q instead of %q at %d,%d", color, probe.color, probe.x, probe.y)
+				}
+			}
+		})
+	}
+}
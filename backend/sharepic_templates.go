@@ -0,0 +1,370 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the loading of template sets, consisting of the parsed
+// SVG templates and their YAML configurations, from one or multiple file
+// systems. Furthermore, an optional templates directory might be watched to
+// reload the templates during runtime.
+//
+// For usage, the currently active set is returned by loadedTemplates.
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"text/template"
+	"text/template/parse"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// sharepicTemplates is a set of all SVG templates and their configurations.
+//
+// A set MUST NOT be altered after being loaded. A reload results in a new set,
+// replacing the old one atomically. Thus, requests keep using the set which
+// was active when they started.
+type sharepicTemplates struct {
+	// sharepicTemplate to be customized for each sharepic, containing all SVG
+	// templates identified by their file name.
+	sharepicTemplate *template.Template
+
+	// sharepicConfs maps the name of a template without a file extension to a
+	// sharepicConf, able to derive a generator instance. Templates with problems
+	// are not included.
+	sharepicConfs map[string]sharepicConf
+
+	// problems found while loading, see validateSharepicConf.
+	problems []templateProblem
+
+	// warnings found while loading, not affecting the templates, see
+	// validateFonts.
+	warnings []templateProblem
+}
+
+// activeTemplates points to the currently used sharepicTemplates.
+var activeTemplates atomic.Pointer[sharepicTemplates]
+
+// loadedTemplates returns the currently active set of templates.
+func loadedTemplates() *sharepicTemplates {
+	return activeTemplates.Load()
+}
+
+// names of all templates having both a configuration and an SVG, sorted.
+func (templates *sharepicTemplates) names() []string {
+	names := make([]string, 0, len(templates.sharepicConfs))
+	for name := range templates.sharepicConfs {
+		if templates.sharepicTemplate.Lookup(name+".svg") != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// logProblems of the loaded templates, including the warnings.
+func (templates *sharepicTemplates) logProblems() {
+	for _, problem := range templates.problems {
+		log.Printf("skipping template due to problem, %v", problem)
+	}
+	for _, warning := range templates.warnings {
+		log.Printf("template warning, %v", warning)
+	}
+}
+
+// placeholders lists all fields, e.g., ".Message", used within the named SVG
+// template, sorted and without duplicates.
+func (templates *sharepicTemplates) placeholders(name string) []string {
+	svgTemplate := templates.sharepicTemplate.Lookup(name + ".svg")
+	if svgTemplate == nil || svgTemplate.Tree == nil {
+		return nil
+	}
+
+	fields := make(map[string]struct{})
+
+	var walk func(node parse.Node)
+	walk = func(node parse.Node) {
+		switch node := node.(type) {
+		case *parse.ListNode:
+			if node == nil {
+				return
+			}
+			for _, n := range node.Nodes {
+				walk(n)
+			}
+		case *parse.ActionNode:
+			walk(node.Pipe)
+		case *parse.IfNode:
+			walk(&node.BranchNode)
+		case *parse.RangeNode:
+			walk(&node.BranchNode)
+		case *parse.WithNode:
+			walk(&node.BranchNode)
+		case *parse.BranchNode:
+			walk(node.Pipe)
+			walk(node.List)
+			walk(node.ElseList)
+		case *parse.TemplateNode:
+			walk(node.Pipe)
+		case *parse.PipeNode:
+			if node == nil {
+				return
+			}
+			for _, cmd := range node.Cmds {
+				walk(cmd)
+			}
+		case *parse.CommandNode:
+			for _, arg := range node.Args {
+				walk(arg)
+			}
+		case *parse.ChainNode:
+			walk(node.Node)
+		case *parse.FieldNode:
+			// Custom fields and image slots are listed by their names, e.g.,
+			// ".Fields.talkTitle" or ".Images.logo".
+			field := "." + node.Ident[0]
+			switch node.Ident[0] {
+			case "Fields", "Images", "ImageMimes":
+				if len(node.Ident) > 1 {
+					field += "." + node.Ident[1]
+				}
+			}
+			fields[field] = struct{}{}
+		}
+	}
+	walk(svgTemplate.Tree.Root)
+
+	placeholders := make([]string, 0, len(fields))
+	for field := range fields {
+		placeholders = append(placeholders, field)
+	}
+	sort.Strings(placeholders)
+	return placeholders
+}
+
+// loadSharepicTemplates from the root of each file system.
+//
+// The file systems are read in order, while later ones take precedence for
+// identically named templates.
+func loadSharepicTemplates(fsyss ...fs.FS) (*sharepicTemplates, error) {
+	templates := &sharepicTemplates{
+		sharepicTemplate: template.New("svg"),
+		sharepicConfs:    make(map[string]sharepicConf),
+	}
+
+	// ymlKeys are the names of all YAML configurations, including faulty ones.
+	ymlKeys := make(map[string]struct{})
+
+	for _, fsys := range fsyss {
+		// Populate template with all SVG files. As ParseFS fails for an empty
+		// match, an empty directory needs to be checked first.
+		svgFiles, err := fs.Glob(fsys, "*.svg")
+		if err != nil {
+			return nil, fmt.Errorf("cannot list SVG templates, %w", err)
+		}
+		if len(svgFiles) > 0 {
+			if _, err := templates.sharepicTemplate.ParseFS(fsys, svgFiles...); err != nil {
+				return nil, fmt.Errorf("cannot parse templates, %w", err)
+			}
+		}
+
+		// Create all template configurations from the YAML files.
+		entries, err := fs.ReadDir(fsys, ".")
+		if err != nil {
+			return nil, fmt.Errorf("cannot read templates directory, %w", err)
+		}
+
+		for _, entry := range entries {
+			if !strings.HasSuffix(entry.Name(), ".yml") {
+				continue
+			}
+
+			// Strip file extension, ".yml".
+			key := strings.TrimSuffix(entry.Name(), ".yml")
+			ymlKeys[key] = struct{}{}
+
+			// A faulty configuration also drops an identically named one of a
+			// previous file system, as its SVG might already be overridden.
+			skip := func(problems ...templateProblem) {
+				templates.problems = append(templates.problems, problems...)
+				delete(templates.sharepicConfs, key)
+			}
+
+			ymlConf, err := fsys.Open(entry.Name())
+			if err != nil {
+				return nil, fmt.Errorf("cannot open template configuration %s, %w", entry.Name(), err)
+			}
+
+			// Unknown fields are most likely typos and result in a problem.
+			var conf sharepicConf
+			decoder := yaml.NewDecoder(ymlConf)
+			decoder.KnownFields(true)
+			decodeErr := decoder.Decode(&conf)
+			_ = ymlConf.Close()
+			if decodeErr != nil {
+				skip(templateProblem{File: entry.Name(), Msg: fmt.Sprintf("cannot decode YAML, %v", decodeErr)})
+				continue
+			}
+
+			if problems := loadPlaceholders(fsys, entry.Name(), &conf); len(problems) > 0 {
+				skip(problems...)
+				continue
+			}
+
+			templates.sharepicConfs[key] = conf
+		}
+	}
+
+	// Each YAML configuration requires an SVG template. An SVG template without
+	// a YAML configuration cannot be used and is reported as a warning, unless
+	// it is used by other templates' layouts. Faulty YAML configurations were
+	// already reported as problems.
+	layoutSvgs := make(map[string]struct{})
+	for _, conf := range templates.sharepicConfs {
+		for _, layout := range conf.Layouts {
+			if layout.Svg != "" {
+				layoutSvgs[layout.Svg] = struct{}{}
+			}
+		}
+	}
+
+	for _, svgTemplate := range templates.sharepicTemplate.Templates() {
+		svgName := svgTemplate.Name()
+		if !strings.HasSuffix(svgName, ".svg") {
+			continue
+		}
+		key := strings.TrimSuffix(svgName, ".svg")
+		if _, ok := layoutSvgs[key]; ok {
+			continue
+		}
+		if _, ok := ymlKeys[key]; !ok {
+			templates.warnings = append(templates.warnings,
+				templateProblem{File: svgName, Msg: "no .yml configuration, thus unused"})
+		}
+	}
+
+	for key, conf := range templates.sharepicConfs {
+		problems := validateSharepicConf(key, conf)
+		if templates.sharepicTemplate.Lookup(key+".svg") == nil {
+			problems = append(problems, templateProblem{File: key + ".yml", Msg: "no .svg template"})
+		}
+		for name, layout := range conf.Layouts {
+			if layout.Svg != "" && templates.sharepicTemplate.Lookup(layout.Svg+".svg") == nil {
+				problems = append(problems, templateProblem{key + ".yml", "layouts." + name + ".svg", "no such .svg template"})
+			}
+		}
+
+		if len(problems) > 0 {
+			templates.problems = append(templates.problems, problems...)
+			delete(templates.sharepicConfs, key)
+			continue
+		}
+		templates.warnings = append(templates.warnings, validateFonts(key, conf)...)
+	}
+
+	for _, problems := range [][]templateProblem{templates.problems, templates.warnings} {
+		sort.Slice(problems, func(i, j int) bool {
+			return problems[i].String() < problems[j].String()
+		})
+	}
+
+	return templates, nil
+}
+
+// loadPlaceholders of the picture box and the image slots from the file system,
+// being next to the template's file.
+func loadPlaceholders(fsys fs.FS, file string, conf *sharepicConf) (problems []templateProblem) {
+	load := func(field string, box *pictureBoxConf) {
+		if box.Placeholder == "" {
+			return
+		}
+
+		data, err := fs.ReadFile(fsys, box.Placeholder)
+		if err != nil {
+			problems = append(problems, templateProblem{file, field, fmt.Sprintf("cannot read placeholder, %v", err)})
+			return
+		}
+		mimeType := detectMime(data)
+		if _, ok := allowedMimeTypes[mimeType]; !ok {
+			problems = append(problems, templateProblem{file, field, fmt.Sprintf("unsupported MIME type %q", mimeType)})
+			return
+		}
+		box.placeholderData = data
+	}
+
+	load("picture_box.placeholder", &conf.PictureBox)
+	for name, slot := range conf.ImageSlots {
+		load("image_slots."+name+".placeholder", &slot)
+		conf.ImageSlots[name] = slot
+	}
+	return
+}
+
+// templatesDirFingerprint summarizes all template files within the directory
+// by their name, size, and modification time to detect changes.
+func templatesDirFingerprint(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+
+	var parts []string
+	// Besides the .svg and .yml files, all other files might be placeholders.
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return "", err
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%d", entry.Name(), info.Size(), info.ModTime().UnixNano()))
+	}
+
+	sort.Strings(parts)
+	return strings.Join(parts, "\n"), nil
+}
+
+// watchTemplatesDir polls the templates directory and reloads all templates
+// after a change. A faulty reload keeps the previous templates active.
+//
+// This function blocks and should be started as its own goroutine.
+func watchTemplatesDir(dir string, interval time.Duration, load func() (*sharepicTemplates, error)) {
+	lastFingerprint, err := templatesDirFingerprint(dir)
+	if err != nil {
+		log.Printf("cannot inspect templates directory %q, %v", dir, err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		fingerprint, err := templatesDirFingerprint(dir)
+		if err != nil {
+			log.Printf("cannot inspect templates directory %q, %v", dir, err)
+			continue
+		}
+		if fingerprint == lastFingerprint {
+			continue
+		}
+		lastFingerprint = fingerprint
+
+		templates, err := load()
+		if err != nil {
+			log.Printf("cannot reload templates, keeping the previous ones, %v", err)
+			continue
+		}
+		templates.logProblems()
+
+		activeTemplates.Store(templates)
+		log.Printf("reloaded %d templates from %q", len(templates.sharepicConfs), dir)
+	}
+}
@@ -0,0 +1,200 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the template configuration's layouts and
+// the picture crops.
+
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestWithLayout(t *testing.T) {
+	var conf sharepicConf
+	err := yaml.Unmarshal([]byte(`
+sharepic:
+  width: 500
+  height: 500
+picture_box:
+  width: 100
+  height: 100
+  grayscale: yes
+  crop: top
+  fit: contain
+  background:
+    color: black
+  mask: circle
+  optional: yes
+  fill:
+    color: red
+message_box:
+  width: 400
+  height: 100
+  align: center
+  vertical_align: middle
+  line_breaking: balanced
+text_boxes:
+  author:
+    field: authorName
+    width: 200
+    height: 20
+    align: right
+image_slots:
+  logo:
+    width: 50
+    height: 50
+    grayscale: yes
+layouts:
+  story:
+    svg: story
+    sharepic:
+      height: 900
+    picture_box:
+      width: 200
+      background:
+        gradient_to: white
+    message_box:
+      margin_height: 600
+    text_boxes:
+      author:
+        disable: yes
+    image_slots:
+      logo:
+        crop: left
+`), &conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		layout string
+		want   func(conf sharepicConf) sharepicConf
+		svg    string
+	}{
+		{
+			name:   "default",
+			layout: defaultLayout,
+			want:   func(conf sharepicConf) sharepicConf { return conf },
+			svg:    "example",
+		},
+		{
+			name:   "story",
+			layout: "story",
+			want: func(conf sharepicConf) sharepicConf {
+				conf.Sharepic.Height = 900
+				conf.PictureBox.Width = 200
+				conf.PictureBox.Background.GradientTo = "white"
+				conf.MessageBox.MarginHeight = 600
+
+				author := conf.TextBoxes["author"]
+				author.Box.Disable = true
+				conf.TextBoxes = map[string]textBoxConf{"author": author}
+
+				logo := conf.ImageSlots["logo"]
+				logo.Crop = "left"
+				conf.ImageSlots = map[string]pictureBoxConf{"logo": logo}
+
+				conf.Layouts = nil
+				return conf
+			},
+			svg: "story",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, svg, err := conf.withLayout("example", test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := test.want(conf); !reflect.DeepEqual(got, want) {
+				t.Errorf("withLayout(%q) = %+v, want %+v", test.layout, got, want)
+			}
+			if svg != test.svg {
+				t.Errorf("withLayout(%q) SVG = %q, want %q", test.layout, svg, test.svg)
+			}
+		})
+	}
+
+	if _, _, err := conf.withLayout("example", "unknown"); err == nil {
+		t.Error("withLayout of an unknown layout succeeded")
+	}
+	if conf.TextBoxes["author"].Box.Disable || conf.ImageSlots["logo"].Crop != "" {
+		t.Error("withLayout modified the template's configuration")
+	}
+}
+
+func TestPictureCropRegion(t *testing.T) {
+	tests := []struct {
+		name                  string
+		crop                  pictureCrop
+		baseWidth, baseHeight float64
+		wishWidth, wishHeight float64
+		width, height         uint
+		x, y                  int
+	}{
+		{"zero crop", pictureCrop{}, 1000, 500, 400, 400, 500, 500, 250, 0},
+		{"centered", defaultPictureCrop, 1000, 500, 400, 400, 500, 500, 250, 0},
+		{"left edge", pictureCrop{0, 0.5, 1}, 1000, 500, 400, 400, 500, 500, 0, 0},
+		{"right edge", pictureCrop{1, 0.5, 1}, 1000, 500, 400, 400, 500, 500, 500, 0},
+		{"near left edge", pictureCrop{0.1, 0.5, 1}, 1000, 500, 400, 400, 500, 500, 0, 0},
+		{"beyond left edge", pictureCrop{-1, 0.5, 1}, 1000, 500, 400, 400, 500, 500, 0, 0},
+		{"beyond right edge", pictureCrop{5, 0.5, 1}, 1000, 500, 400, 400, 500, 500, 500, 0},
+		{"NaN focus", pictureCrop{math.NaN(), math.NaN(), 1}, 1000, 500, 400, 400, 500, 500, 0, 0},
+		{"top edge", pictureCrop{0.5, 0, 1}, 500, 1000, 400, 200, 500, 250, 0, 0},
+		{"bottom edge", pictureCrop{0.5, 1, 1}, 500, 1000, 400, 200, 500, 250, 0, 750},
+		{"near bottom edge", pictureCrop{0.5, 0.9, 1}, 500, 1000, 400, 200, 500, 250, 0, 750},
+		{"vertically centered", pictureCrop{0.5, 0.5, 1}, 500, 1000, 400, 200, 500, 250, 0, 375},
+		{"zoom", pictureCrop{0.5, 0.5, 2}, 1000, 500, 400, 400, 250, 250, 375, 125},
+		{"zoom top left", pictureCrop{0, 0, 2}, 1000, 500, 400, 400, 250, 250, 0, 0},
+		{"zoom bottom right", pictureCrop{1, 1, 2}, 1000, 500, 400, 400, 250, 250, 750, 250},
+		{"maximum zoom", pictureCrop{0.5, 0.5, 10}, 1000, 500, 400, 400, 125, 125, 437, 187},
+		{"zoom below one", pictureCrop{0.5, 0.5, 0.5}, 1000, 500, 400, 400, 500, 500, 250, 0},
+		{"missing zoom", pictureCrop{0.2, 0.2, 0}, 1000, 500, 400, 400, 500, 500, 0, 0},
+		{"NaN zoom", pictureCrop{0.5, 0.5, math.NaN()}, 1000, 500, 400, 400, 500, 500, 250, 0},
+		{"smaller picture", defaultPictureCrop, 100, 50, 400, 400, 50, 50, 25, 0},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			width, height, x, y := test.crop.region(test.baseWidth, test.baseHeight, test.wishWidth, test.wishHeight)
+			if width != test.width || height != test.height || x != test.x || y != test.y {
+				t.Errorf("region(%v, %v, %v, %v) = %d, %d, %d, %d, want %d, %d, %d, %d",
+					test.baseWidth, test.baseHeight, test.wishWidth, test.wishHeight,
+					width, height, x, y, test.width, test.height, test.x, test.y)
+			}
+		})
+	}
+}
+
+func TestPictureBoxCrop(t *testing.T) {
+	requested := pictureCrop{FocusX: 0.2, FocusY: 0.8, Zoom: 2}
+
+	tests := []struct {
+		name      string
+		mode      string
+		requested pictureCrop
+		want      pictureCrop
+	}{
+		{"default mode", "", pictureCrop{}, defaultPictureCrop},
+		{"mode", "top", pictureCrop{}, cropModes["top"]},
+		{"mode with default request", "bottom", defaultPictureCrop, cropModes["bottom"]},
+		{"request", "", requested, requested},
+		{"request overrides mode", "left", requested, requested},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := (pictureBoxConf{Crop: test.mode}).crop(test.requested); got != test.want {
+				t.Errorf("crop(%v) = %v, want %v", test.requested, got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,365 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the validation of template configurations, reporting
+// inconsistencies at load time instead of failing at request time.
+//
+// For usage, validateSharepicConf checks a single configuration, while the
+// pairing of SVG and YAML files is checked within loadSharepicTemplates.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// templateProblem describes an inconsistency within a template's files.
+type templateProblem struct {
+	File  string
+	Field string
+	Msg   string
+}
+
+func (problem templateProblem) String() string {
+	if problem.Field == "" {
+		return fmt.Sprintf("%s: %s", problem.File, problem.Msg)
+	}
+	return fmt.Sprintf("%s: %s: %s", problem.File, problem.Field, problem.Msg)
+}
+
+// validateSharepicConf reports all problems of the named template's
+// configuration. An empty result indicates a usable configuration.
+func validateSharepicConf(name string, conf sharepicConf) (problems []templateProblem) {
+	file := name + ".yml"
+	report := func(field, format string, a ...any) {
+		problems = append(problems, templateProblem{file, field, fmt.Sprintf(format, a...)})
+	}
+
+	positive := []struct {
+		field string
+		value int
+	}{
+		{"sharepic.width", conf.Sharepic.Width},
+		{"sharepic.height", conf.Sharepic.Height},
+	}
+	for _, p := range positive {
+		if p.value <= 0 {
+			report(p.field, "must be positive, not %d", p.value)
+		}
+	}
+
+	validatePictureBox(report, "picture_box.", conf.PictureBox)
+	for _, name := range conf.slotNames() {
+		if !namePattern.MatchString(name) {
+			report("image_slots."+name, "name must start with a letter, followed by letters, digits, or underscores")
+		}
+		if conf.ImageSlots[name].Disable {
+			report("image_slots."+name+".disable", "is only supported by the picture_box")
+		}
+		validatePictureBox(report, "image_slots."+name+".", conf.ImageSlots[name])
+	}
+
+	nonNegative := []struct {
+		field string
+		value int
+	}{
+		{"max_length.message", conf.MaxLength.Message},
+		{"max_length.author", conf.MaxLength.Author},
+		{"max_length.description", conf.MaxLength.Description},
+	}
+	for _, n := range nonNegative {
+		if n.value < 0 {
+			report(n.field, "must not be negative, not %d", n.value)
+		}
+	}
+
+	if _, ok := conf.Layouts[defaultLayout]; ok {
+		report("layouts."+defaultLayout, "name is reserved for the template's own geometry")
+	}
+	if _, ok := conf.Layouts[allLayouts]; ok {
+		report("layouts."+allLayouts, "name is reserved for requesting all layouts")
+	}
+
+	if conf.Output.Format != "" {
+		if _, err := lookupOutputFormat(conf.Output.Format); err != nil {
+			report("output.format", "%v", err)
+		}
+	}
+	if _, ok := lookupHyphenator(conf.Language); conf.Language != "" && !ok {
+		report("language", "must be one of %s, not %q", strings.Join(hyphenationLanguages(), ", "), conf.Language)
+	}
+
+	for _, name := range conf.fieldNames() {
+		field := conf.Fields[name]
+		if !namePattern.MatchString(name) {
+			report("fields."+name, "name must start with a letter, followed by letters, digits, or underscores")
+		}
+		for _, textField := range textFields {
+			if name == textField {
+				report("fields."+name, "name is reserved for the built-in field")
+			}
+		}
+		if field.MaxLength < 0 {
+			report("fields."+name+".max_length", "must not be negative, not %d", field.MaxLength)
+		}
+		if err := field.check(field.Default); err != nil {
+			report("fields."+name, "%v", err)
+		}
+	}
+
+	for _, name := range conf.textBoxNames() {
+		textBox := conf.TextBoxes[name]
+		field := "text_boxes." + name + "."
+		_, isTextField := (sharepicCustomization{}).textField(textBox.Field)
+		if _, isCustomField := conf.Fields[textBox.Field]; !isTextField && !isCustomField {
+			report(field+"field", "must be one of %s, or a custom field, not %q", strings.Join(textFields, ", "), textBox.Field)
+		}
+		if !textBox.Box.Disable {
+			validateTextBox(report, field+"font.", field, textBox.Font, textBox.Box, conf.Sharepic)
+		}
+	}
+
+	// The following checks are only relevant for an enabled message box.
+	if !conf.MessageBox.Disable {
+		validateTextBox(report, "font.", "message_box.", conf.Font, conf.MessageBox, conf.Sharepic)
+	}
+
+	// Each layout's geometry is checked like the template's own geometry, only
+	// reporting problems of the overridden parts. Problems inherited from the
+	// template's own geometry were already reported above.
+	ownProblems := make(map[templateProblem]struct{}, len(problems))
+	for _, problem := range problems {
+		ownProblems[problem] = struct{}{}
+	}
+	for _, layout := range conf.layoutNames()[1:] {
+		layoutConf, _, _ := conf.withLayout(name, layout)
+		for _, problem := range validateSharepicConf(name, layoutConf) {
+			if _, ok := ownProblems[problem]; ok {
+				continue
+			}
+			if !strings.HasPrefix(problem.Field, "sharepic.") &&
+				!strings.HasPrefix(problem.Field, "message_box.") &&
+				!isPictureBoxLayoutField(problem.Field) &&
+				!isTextBoxGeometryField(problem.Field) {
+				continue
+			}
+			problem.Field = fmt.Sprintf("layouts.%s.%s", layout, problem.Field)
+			problems = append(problems, problem)
+		}
+
+		for textBox := range conf.Layouts[layout].TextBoxes {
+			if _, ok := conf.TextBoxes[textBox]; !ok {
+				report(fmt.Sprintf("layouts.%s.text_boxes.%s", layout, textBox), "no such text box within text_boxes")
+			}
+		}
+		for slot := range conf.Layouts[layout].ImageSlots {
+			if _, ok := conf.ImageSlots[slot]; !ok {
+				report(fmt.Sprintf("layouts.%s.image_slots.%s", layout, slot), "no such image slot within image_slots")
+			}
+		}
+	}
+
+	return
+}
+
+// validateFonts reports the fonts of the named template's text boxes without a
+// font file within the font_dirs as warnings. As ImageMagick might resolve such
+// a font otherwise, e.g., by fontconfig, these are no problems, but the font is
+// assumed to cover the Latin script only, see fontCovers.
+func validateFonts(name string, conf sharepicConf) (warnings []templateProblem) {
+	if len(fontDirs) == 0 {
+		return nil
+	}
+
+	check := func(fontField string, font fontConf) {
+		type namedFont struct {
+			field string
+			name  string
+		}
+		fonts := []namedFont{
+			{"name", font.Name},
+			{"bold", font.Bold},
+			{"italic", font.Italic},
+			{"bold_italic", font.BoldItalic},
+		}
+		for i, fallback := range font.Fallbacks {
+			fonts = append(fonts, namedFont{fmt.Sprintf("fallbacks[%d]", i), fallback})
+		}
+		for _, f := range fonts {
+			if f.name != "" && !fontExists(f.name) {
+				warnings = append(warnings, templateProblem{name + ".yml", fontField + f.field, fmt.Sprintf("no font file for %q within font_dirs", f.name)})
+			}
+		}
+	}
+
+	if !conf.MessageBox.Disable {
+		check("font.", conf.Font)
+	}
+	for _, textBox := range conf.textBoxNames() {
+		if !conf.TextBoxes[textBox].Box.Disable {
+			check("text_boxes."+textBox+".font.", conf.TextBoxes[textBox].Font)
+		}
+	}
+	return
+}
+
+// validatePictureBox reports the problems of a picture box or an image slot.
+// The geometry of a disabled box is not checked.
+func validatePictureBox(report func(field, format string, a ...any), field string, box pictureBoxConf) {
+	if box.Disable {
+		if box.Optional {
+			report(field+"optional", "cannot be combined with disable")
+		}
+		return
+	}
+
+	if box.Optional && box.Placeholder == "" && box.Fill.Color == "" {
+		report(field+"optional", "requires either a placeholder or a fill color")
+	}
+	if box.Fill.GradientTo != "" && box.Fill.Color == "" {
+		report(field+"fill.color", "must be set for a gradient")
+	}
+
+	if box.Width <= 0 {
+		report(field+"width", "must be positive, not %d", box.Width)
+	}
+	if box.Height <= 0 {
+		report(field+"height", "must be positive, not %d", box.Height)
+	}
+	if _, ok := cropModes[box.Crop]; !ok {
+		report(field+"crop", "must be one of center, top, bottom, left, or right, not %q", box.Crop)
+	}
+
+	switch box.Fit {
+	case "", fitCover, fitBlur:
+	case fitContain:
+		if box.Background.Color == "" {
+			report(field+"background.color", "must be set for the contain fit mode")
+		}
+	default:
+		report(field+"fit", "must be one of cover, contain, or blur, not %q", box.Fit)
+	}
+	if box.Background.GradientTo != "" && box.Background.Color == "" && box.Fit != fitContain {
+		report(field+"background.color", "must be set for a gradient")
+	}
+
+	switch box.Mask {
+	case "", maskCircle:
+	case maskRounded:
+		if box.Radius <= 0 {
+			report(field+"radius", "must be positive for the rounded mask, not %g", box.Radius)
+		}
+	default:
+		report(field+"mask", "must be either circle or rounded, not %q", box.Mask)
+	}
+}
+
+// isPictureBoxLayoutField reports whether the field of the picture box or an
+// image slot might be overridden by a layout, being none of its source fields.
+func isPictureBoxLayoutField(field string) bool {
+	if strings.HasPrefix(field, "image_slots.") {
+		if parts := strings.SplitN(field, ".", 3); len(parts) == 3 {
+			field = parts[2]
+		}
+	} else if name := strings.TrimPrefix(field, "picture_box."); name != field {
+		field = name
+	} else {
+		return false
+	}
+	switch field {
+	case "width", "height", "grayscale", "crop", "fit", "background.color", "mask", "radius":
+		return true
+	default:
+		return false
+	}
+}
+
+// isTextBoxGeometryField reports whether the field belongs to the geometry of a
+// text box, being neither its font nor its field.
+func isTextBoxGeometryField(field string) bool {
+	parts := strings.SplitN(field, ".", 3)
+	return len(parts) == 3 && parts[0] == "text_boxes" &&
+		!strings.HasPrefix(parts[2], "font.") && parts[2] != "field"
+}
+
+// validateTextBox reports the problems of a box's font and geometry within the
+// canvas, the fields being prefixed by fontField resp. boxField.
+func validateTextBox(report func(field, format string, a ...any), fontField, boxField string, font fontConf, box messageBoxConf, canvas canvasConf) {
+	if box.Width <= 0 {
+		report(boxField+"width", "must be positive, not %d", box.Width)
+	}
+	if box.Height <= 0 {
+		report(boxField+"height", "must be positive, not %d", box.Height)
+	}
+	if box.MarginWidth < 0 {
+		report(boxField+"margin_width", "must not be negative, not %d", box.MarginWidth)
+	}
+	if box.MarginHeight < 0 {
+		report(boxField+"margin_height", "must not be negative, not %d", box.MarginHeight)
+	}
+
+	if font.Name == "" {
+		report(fontField+"name", "must be set for an enabled text box")
+	}
+	if font.Color == "" {
+		report(fontField+"color", "must be set for an enabled text box")
+	}
+	sizeRange := font.SizeRange
+	switch {
+	case len(font.Sizes) == 0 && sizeRange.Max == 0:
+		report(fontField+"sizes", "either %ssizes or %ssize_range must be set for an enabled text box", fontField, fontField)
+	case len(font.Sizes) > 0 && sizeRange.Max != 0:
+		report(fontField+"size_range", "must not be set next to %ssizes", fontField)
+	case sizeRange.Max != 0 && (sizeRange.Min <= 0 || sizeRange.Max < sizeRange.Min):
+		report(fontField+"size_range", "requires 0 < min <= max, not %d and %d", sizeRange.Min, sizeRange.Max)
+	}
+	for i, size := range font.Sizes {
+		if size <= 0 {
+			report(fmt.Sprintf("%ssizes[%d]", fontField, i), "must be positive, not %d", size)
+		}
+	}
+	for i, fallback := range font.Fallbacks {
+		if fallback == "" {
+			report(fmt.Sprintf("%sfallbacks[%d]", fontField, i), "must not be empty")
+		}
+	}
+
+	if font.Outline.Color != "" && font.Outline.Width <= 0 {
+		report(fontField+"outline.width", "must be positive for an outline, not %v", font.Outline.Width)
+	}
+	if font.Shadow.Blur < 0 {
+		report(fontField+"shadow.blur", "must not be negative, not %v", font.Shadow.Blur)
+	}
+	if font.Marker.Padding < 0 {
+		report(fontField+"marker.padding", "must not be negative, not %d", font.Marker.Padding)
+	}
+	if font.Marker.Radius < 0 {
+		report(fontField+"marker.radius", "must not be negative, not %v", font.Marker.Radius)
+	}
+
+	switch box.Align {
+	case "", alignLeft, alignCenter, alignRight:
+	default:
+		report(boxField+"align", "must be one of left, center, or right, not %q", box.Align)
+	}
+	switch box.VerticalAlign {
+	case "", alignTop, alignMiddle, alignBottom:
+	default:
+		report(boxField+"vertical_align", "must be one of top, middle, or bottom, not %q", box.VerticalAlign)
+	}
+
+	switch box.LineBreaking {
+	case "", lineBreakingGreedy, lineBreakingBalanced:
+	default:
+		report(boxField+"line_breaking", "must be either greedy or balanced, not %q", box.LineBreaking)
+	}
+
+	if right := box.MarginWidth + box.Width; right > canvas.Width {
+		report(boxField+"width", "box ends at %d, exceeding sharepic.width %d", right, canvas.Width)
+	}
+	if bottom := box.MarginHeight + box.Height; bottom > canvas.Height {
+		report(boxField+"height", "box ends at %d, exceeding sharepic.height %d", bottom, canvas.Height)
+	}
+}
@@ -0,0 +1,239 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This file contains the tests of the template configurations' validation.
+
+package main
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// validSharepicConf to be altered by each test case of validateSharepicConf.
+const validSharepicConf = `
+sharepic:
+  width: 500
+  height: 500
+picture_box:
+  width: 100
+  height: 100
+font:
+  name: DejaVu-Sans
+  color: white
+  sizes: [40, 20]
+message_box:
+  width: 400
+  height: 100
+  margin_width: 50
+  margin_height: 300
+fields:
+  talkDate:
+    type: date
+text_boxes:
+  date:
+    field: talkDate
+    font:
+      name: DejaVu-Sans
+      color: white
+      size_range:
+        min: 10
+        max: 20
+    width: 200
+    height: 20
+layouts:
+  story:
+    sharepic:
+      height: 900
+`
+
+func TestValidateSharepicConf(t *testing.T) {
+	tests := []struct {
+		name  string
+		alter func(conf *sharepicConf)
+		want  []string
+	}{
+		{
+			name:  "valid",
+			alter: func(conf *sharepicConf) {},
+		},
+		{
+			name:  "zero width",
+			alter: func(conf *sharepicConf) { conf.Sharepic.Width = 0 },
+			// The boxes exceed the canvas as well, while the layout inherits
+			// these problems without reporting them again.
+			want: []string{"message_box.width", "sharepic.width", "text_boxes.date.width"},
+		},
+		{
+			name:  "negative max length",
+			alter: func(conf *sharepicConf) { conf.MaxLength.Message = -1 },
+			want:  []string{"max_length.message"},
+		},
+		{
+			name:  "empty sizes",
+			alter: func(conf *sharepicConf) { conf.Font.Sizes = nil },
+			want:  []string{"font.sizes"},
+		},
+		{
+			name:  "non-positive size",
+			alter: func(conf *sharepicConf) { conf.Font.Sizes = []int{40, 0} },
+			want:  []string{"font.sizes[1]"},
+		},
+		{
+			name: "sizes and size range",
+			alter: func(conf *sharepicConf) {
+				date := conf.TextBoxes["date"]
+				date.Font.Sizes = []int{10}
+				conf.TextBoxes["date"] = date
+			},
+			want: []string{"text_boxes.date.font.size_range"},
+		},
+		{
+			name: "inverted size range",
+			alter: func(conf *sharepicConf) {
+				date := conf.TextBoxes["date"]
+				date.Font.SizeRange.Min = 30
+				conf.TextBoxes["date"] = date
+			},
+			want: []string{"text_boxes.date.font.size_range"},
+		},
+		{
+			name:  "box exceeding the canvas",
+			alter: func(conf *sharepicConf) { conf.MessageBox.MarginHeight = 450 },
+			want:  []string{"message_box.height"},
+		},
+		{
+			name: "box exceeding the layout's canvas",
+			alter: func(conf *sharepicConf) {
+				height := 350
+				conf.Layouts["story"] = layoutConf{Sharepic: canvasLayoutConf{Height: &height}}
+			},
+			want: []string{"layouts.story.message_box.height"},
+		},
+		{
+			name:  "disabled message box",
+			alter: func(conf *sharepicConf) { conf.MessageBox = messageBoxConf{Disable: true} },
+		},
+		{
+			name: "unknown text box field",
+			alter: func(conf *sharepicConf) {
+				date := conf.TextBoxes["date"]
+				date.Field = "talkTitle"
+				conf.TextBoxes["date"] = date
+			},
+			want: []string{"text_boxes.date.field"},
+		},
+		{
+			name: "disabled text box",
+			alter: func(conf *sharepicConf) {
+				conf.TextBoxes["date"] = textBoxConf{Field: "talkDate", Box: messageBoxConf{Disable: true}}
+			},
+		},
+		{
+			name: "invalid default date",
+			alter: func(conf *sharepicConf) {
+				conf.Fields["talkDate"] = fieldConf{Type: fieldTypeDate, Default: "2024-02-30"}
+			},
+			want: []string{"fields.talkDate"},
+		},
+		{
+			name:  "unsupported field type",
+			alter: func(conf *sharepicConf) { conf.Fields["talkDate"] = fieldConf{Type: "choice"} },
+			want:  []string{"fields.talkDate"},
+		},
+		{
+			name:  "reserved field name",
+			alter: func(conf *sharepicConf) { conf.Fields["message"] = fieldConf{} },
+			want:  []string{"fields.message"},
+		},
+		{
+			name:  "invalid field name",
+			alter: func(conf *sharepicConf) { conf.Fields["talk-date"] = fieldConf{} },
+			want:  []string{"fields.talk-date"},
+		},
+		{
+			name:  "bad fit",
+			alter: func(conf *sharepicConf) { conf.PictureBox.Fit = "stretch" },
+			want:  []string{"picture_box.fit"},
+		},
+		{
+			name:  "contain without background",
+			alter: func(conf *sharepicConf) { conf.PictureBox.Fit = fitContain },
+			want:  []string{"picture_box.background.color"},
+		},
+		{
+			name:  "bad crop",
+			alter: func(conf *sharepicConf) { conf.PictureBox.Crop = "middle" },
+			want:  []string{"picture_box.crop"},
+		},
+		{
+			name:  "rounded mask without radius",
+			alter: func(conf *sharepicConf) { conf.PictureBox.Mask = maskRounded },
+			want:  []string{"picture_box.radius"},
+		},
+		{
+			name:  "optional without fill",
+			alter: func(conf *sharepicConf) { conf.PictureBox.Optional = true },
+			want:  []string{"picture_box.optional"},
+		},
+		{
+			name:  "disabled picture box",
+			alter: func(conf *sharepicConf) { conf.PictureBox = pictureBoxConf{Disable: true} },
+		},
+		{
+			name:  "disabled image slot",
+			alter: func(conf *sharepicConf) { conf.ImageSlots = map[string]pictureBoxConf{"logo": {Disable: true}} },
+			want:  []string{"image_slots.logo.disable"},
+		},
+		{
+			name:  "reserved default layout",
+			alter: func(conf *sharepicConf) { conf.Layouts[defaultLayout] = layoutConf{} },
+			want:  []string{"layouts.default"},
+		},
+		{
+			name:  "reserved all layout",
+			alter: func(conf *sharepicConf) { conf.Layouts[allLayouts] = layoutConf{} },
+			want:  []string{"layouts.all"},
+		},
+		{
+			name: "layout of an unknown text box",
+			alter: func(conf *sharepicConf) {
+				conf.Layouts["story"] = layoutConf{TextBoxes: map[string]boxLayoutConf{"title": {}}}
+			},
+			want: []string{"layouts.story.text_boxes.title"},
+		},
+		{
+			name:  "unknown output format",
+			alter: func(conf *sharepicConf) { conf.Output.Format = "bmp" },
+			want:  []string{"output.format"},
+		},
+		{
+			name:  "unknown language",
+			alter: func(conf *sharepicConf) { conf.Language = "xx" },
+			want:  []string{"language"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var conf sharepicConf
+			if err := yaml.Unmarshal([]byte(validSharepicConf), &conf); err != nil {
+				t.Fatal(err)
+			}
+			test.alter(&conf)
+
+			var fields []string
+			for _, problem := range validateSharepicConf("example", conf) {
+				fields = append(fields, problem.Field)
+			}
+			sort.Strings(fields)
+			if !reflect.DeepEqual(fields, test.want) {
+				t.Errorf("validateSharepicConf = %q, want %q", fields, test.want)
+			}
+		})
+	}
+}
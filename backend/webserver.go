@@ -8,20 +8,43 @@
 package main
 
 import (
+	"archive/zip"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
 )
 
 // sharepicResult is the internal data type for a SharePic generation request.
+//
+// If all layouts were requested, they are listed within Layouts, while the
+// first layout is also used for the MimeType and Image fields.
+//
+// For JPEG output, the Image is also given as Jpeg, being the field of former
+// versions supporting only JPEG.
 type sharepicResult struct {
-	Error string
-	Jpeg  []byte
+	Error    string
+	MimeType string
+	Image    []byte
+	Jpeg     []byte `json:",omitempty"`
+
+	Layouts []sharepicResultLayout `json:",omitempty"`
+}
+
+// sharepicResultLayout is the sharepic for one layout within a sharepicResult.
+type sharepicResultLayout struct {
+	Layout    string
+	MimeType  string
+	Extension string `json:"-"`
+	Image     []byte
 }
 
 // Status code for HTTP headers.
@@ -61,22 +84,22 @@ func detectMime(imgData []byte) (mime string) {
 	return
 }
 
-// extractImageFromRequest returns the POSTed image.
-func extractImageFromRequest(r *http.Request) (data []byte, mime string, err error) {
-	img, imgHeader, err := r.FormFile("img")
+// readImageUpload returns an uploaded image after checking its size and MIME.
+func readImageUpload(imgHeader *multipart.FileHeader) (data []byte, mime string, err error) {
+	if size := imgHeader.Size; size > maxImageSize {
+		return nil, "", fmt.Errorf("size %d is greater maximum size %d", size, maxImageSize)
+	}
+
+	img, err := imgHeader.Open()
 	if err != nil {
-		return nil, "", fmt.Errorf("cannot fetch `img` form, %v", err)
+		return nil, "", fmt.Errorf("cannot open upload, %v", err)
 	}
 
 	defer img.Close()
 
-	if size := imgHeader.Size; size > maxImageSize {
-		return nil, "", fmt.Errorf("`img`'s size %d is greater maximum size %d", size, maxImageSize)
-	}
-
 	imgData, err := io.ReadAll(img)
 	if err != nil {
-		return nil, "", fmt.Errorf("cannot read `img`, %v", err)
+		return nil, "", fmt.Errorf("cannot read upload, %v", err)
 	}
 
 	mimeType := detectMime(imgData)
@@ -87,6 +110,68 @@ func extractImageFromRequest(r *http.Request) (data []byte, mime string, err err
 	return imgData, mimeType, nil
 }
 
+// extractImageFromRequest returns the POSTed image, being empty if none was
+// posted, e.g., for a template with an optional picture.
+func extractImageFromRequest(r *http.Request) (data []byte, mime string, err error) {
+	img, imgHeader, err := r.FormFile("img")
+	if errors.Is(err, http.ErrMissingFile) {
+		return nil, "", nil
+	} else if err != nil {
+		return nil, "", fmt.Errorf("cannot fetch `img` form, %v", err)
+	}
+	_ = img.Close()
+
+	data, mime, err = readImageUpload(imgHeader)
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot use `img`, %v", err)
+	}
+	return
+}
+
+// imageSlotPrefix of image slots within HTTP forms, e.g., "img.logo".
+const imageSlotPrefix = "img."
+
+// extractSlotImagesFromRequest returns the POSTed images of image slots, each
+// key being prefixed by imageSlotPrefix.
+func extractSlotImagesFromRequest(r *http.Request) (map[string][]byte, error) {
+	images := make(map[string][]byte)
+	for key, imgHeaders := range r.MultipartForm.File {
+		name := strings.TrimPrefix(key, imageSlotPrefix)
+		if name == key || len(imgHeaders) == 0 {
+			continue
+		}
+
+		data, _, err := readImageUpload(imgHeaders[0])
+		if err != nil {
+			return nil, fmt.Errorf("cannot use `%s`, %v", key, err)
+		}
+		images[name] = data
+	}
+	return images, nil
+}
+
+// cleanInputString trims the input and collapses all whitespace.
+func cleanInputString(rawInput string) string {
+	rawCleaned := strings.TrimSpace(rawInput)
+	rawCleaned = regexp.MustCompile(`\s+`).ReplaceAllString(rawCleaned, " ")
+	return rawCleaned
+}
+
+// cleanInputText is like cleanInputString, but keeps single newlines as line
+// breaks and blank lines as paragraph separators.
+func cleanInputText(rawInput string) string {
+	rawInput = strings.ReplaceAll(rawInput, "\r\n", "\n")
+
+	lines := strings.Split(rawInput, "\n")
+	for i, line := range lines {
+		lines[i] = cleanInputString(line)
+	}
+
+	rawCleaned := strings.Trim(strings.Join(lines, "\n"), "\n")
+	rawCleaned = regexp.MustCompile(`\n{3,}`).ReplaceAllString(rawCleaned, "\n\n")
+	return rawCleaned
+}
+
 // extractStringFromRequest returns the POSTed string for key, or falls back to
 // a default if the key either does not exist or the value is faulty.
 func extractStringFromRequest(r *http.Request, key, fallback string) string {
@@ -95,22 +180,74 @@ func extractStringFromRequest(r *http.Request, key, fallback string) string {
 		return fallback
 	}
 
-	rawCleaned := strings.TrimSpace(rawInput)
-	rawCleaned = regexp.MustCompile(`\s+`).ReplaceAllString(rawCleaned, " ")
-	return rawCleaned
+	return cleanInputString(rawInput)
 }
 
-// sharepicRawResponse writes back the result either as a JPEG or text.
+// extractFloatFromRequest returns the POSTed number for key, or falls back to
+// a default if the key either does not exist or the value is faulty.
+func extractFloatFromRequest(r *http.Request, key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(strings.TrimSpace(r.FormValue(key)), 64)
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// extractTextFromRequest is like extractStringFromRequest, but for multi-line
+// text as returned by cleanInputText.
+func extractTextFromRequest(r *http.Request, key, fallback string) string {
+	rawInput := r.FormValue(key)
+	if rawInput == "" {
+		return fallback
+	}
+
+	return cleanInputText(rawInput)
+}
+
+// extractFieldsFromRequest returns the POSTed values of custom fields, each key
+// being prefixed by fieldPrefix, e.g., "field.talkTitle". The values are
+// cleaned as by cleanInputText.
+func extractFieldsFromRequest(r *http.Request) map[string]string {
+	fields := make(map[string]string)
+	for key := range r.Form {
+		if name := strings.TrimPrefix(key, fieldPrefix); name != key {
+			fields[name] = extractTextFromRequest(r, key, "")
+		}
+	}
+	return fields
+}
+
+// sharepicRawResponse writes back the result either as an image, a ZIP file of
+// multiple layouts, or text.
 func sharepicRawResponse(result sharepicResult, w http.ResponseWriter, _ *http.Request) {
 	if result.Error != "" {
 		http.Error(w, result.Error, result.Status())
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/jpeg")
+	if len(result.Layouts) > 0 {
+		var zipBuff bytes.Buffer
+		zipWriter := zip.NewWriter(&zipBuff)
+		for _, layout := range result.Layouts {
+			if err := addZipFile(zipWriter, layout.Layout+"."+layout.Extension, layout.Image); err != nil {
+				http.Error(w, fmt.Sprintf("cannot create ZIP file, %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		if err := zipWriter.Close(); err != nil {
+			http.Error(w, fmt.Sprintf("cannot create ZIP file, %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		result.MimeType = "application/zip"
+		result.Image = zipBuff.Bytes()
+		w.Header().Set("Content-Disposition", `attachment; filename="sharepics.zip"`)
+	}
+
+	w.Header().Set("Content-Type", result.MimeType)
 	w.WriteHeader(result.Status())
 
-	if _, err := w.Write(result.Jpeg); err != nil {
+	if _, err := w.Write(result.Image); err != nil {
 		log.Printf("cannot write sharepic, %v", err)
 	}
 }
@@ -174,18 +311,321 @@ func sharepicHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	sharepicData, err := MakeSharepic(r.Context(), sharepicCustomization{
+	slotsData, err := extractSlotImagesFromRequest(r)
+	if err != nil {
+		result.Error = fmt.Sprintf("cannot extract image, %v", err)
+		return
+	}
+
+	layout := extractStringFromRequest(r, "layout", "")
+
+	sharepics, err := MakeSharepics(r.Context(), sharepicCustomization{
 		Name:    extractStringFromRequest(r, "template", "ilovefs"),
-		Message: extractStringFromRequest(r, "message", ""),
+		Message: extractTextFromRequest(r, "message", ""),
+		Format:  extractStringFromRequest(r, "outputFormat", ""),
+		Layout:  layout,
+
+		Language: extractStringFromRequest(r, "language", ""),
+
+		Crop: pictureCrop{
+			FocusX: extractFloatFromRequest(r, "focusX", defaultPictureCrop.FocusX),
+			FocusY: extractFloatFromRequest(r, "focusY", defaultPictureCrop.FocusY),
+			Zoom:   extractFloatFromRequest(r, "zoom", defaultPictureCrop.Zoom),
+		},
 
 		AuthorName: extractStringFromRequest(r, "authorName", "Jane Doe"),
 		AuthorDesc: extractStringFromRequest(r, "authorDesc", ""),
-	}, imgData)
+
+		Fields: extractFieldsFromRequest(r),
+	}, inputImages{Picture: imgData, Slots: slotsData})
 	if err != nil {
 		result.Error = fmt.Sprintf("cannot create sharepic, %v", err)
 		return
 	}
-	result.Jpeg = sharepicData
+	result.MimeType = sharepics[0].Format.Mime
+	result.Image = sharepics[0].Data
+	if result.MimeType == "image/jpeg" {
+		result.Jpeg = result.Image
+	}
+
+	if layout == allLayouts {
+		for _, sharepic := range sharepics {
+			result.Layouts = append(result.Layouts, sharepicResultLayout{
+				Layout:    sharepic.Layout,
+				MimeType:  sharepic.Format.Mime,
+				Extension: sharepic.Format.Extension,
+				Image:     sharepic.Data,
+			})
+		}
+	}
+}
+
+// batchHandler creates sharepics for all rows of a POSTed manifest and sends
+// them back as a ZIP file, including a report.csv for each row's status.
+//
+// The manifest is expected as the `manifest` form file, while the referenced
+// images are uploaded as `images` form files, identified by their file name.
+func batchHandler(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+	defer func() {
+		log.Printf("batch request took %v to complete", time.Since(startTime))
+	}()
+
+	if method := r.Method; method != "POST" {
+		http.Error(w, "HTTP POST only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Besides the memory, the whole body is limited, as the remaining uploads
+	// would be stored as temporary files.
+	r.Body = http.MaxBytesReader(w, r.Body, batchMaxSize)
+	if err := r.ParseMultipartForm(maxImageSize); err != nil {
+		status := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, fmt.Sprintf("cannot parse multipart form, %v", err), status)
+		return
+	}
+	defer func() { _ = r.MultipartForm.RemoveAll() }()
+
+	manifest, _, err := r.FormFile("manifest")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("cannot fetch `manifest` form, %v", err), http.StatusBadRequest)
+		return
+	}
+	rows, err := parseBatchManifest(manifest)
+	_ = manifest.Close()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	uploads := make(map[string]*multipart.FileHeader)
+	for _, imgHeader := range r.MultipartForm.File["images"] {
+		uploads[imgHeader.Filename] = imgHeader
+	}
+
+	// The ZIP file is streamed to the client, each sharepic once created.
+	// Thus, errors can only be logged after the header was sent.
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="sharepics.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	zipWriter := zip.NewWriter(w)
+	results := RenderBatch(r.Context(), rows, func(ref string) ([]byte, error) {
+		imgHeader, ok := uploads[ref]
+		if !ok {
+			return nil, fmt.Errorf("no such upload")
+		}
+		imgData, _, err := readImageUpload(imgHeader)
+		return imgData, err
+	}, func(name string, data []byte) error {
+		return addZipFile(zipWriter, name, data)
+	})
+
+	var reportBuff bytes.Buffer
+	if err := writeBatchReport(&reportBuff, results); err != nil {
+		log.Printf("cannot create report, %v", err)
+		return
+	}
+	if err := addZipFile(zipWriter, "report.csv", reportBuff.Bytes()); err != nil {
+		log.Printf("cannot write ZIP file, %v", err)
+		return
+	}
+	if err := zipWriter.Close(); err != nil {
+		log.Printf("cannot write ZIP file, %v", err)
+	}
+}
+
+// addZipFile with the given name and content to the ZIP writer.
+func addZipFile(zipWriter *zip.Writer, name string, data []byte) error {
+	f, err := zipWriter.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+// templateInfo describes a loaded template for the templates catalogue.
+type templateInfo struct {
+	Name string
+
+	Width  int
+	Height int
+
+	MessageDisabled bool
+
+	// PictureDisabled and PictureOptional tell whether a picture is unused
+	// resp. might be omitted.
+	PictureDisabled bool
+	PictureOptional bool
+
+	MaxLength struct {
+		Message     int
+		Author      int
+		Description int
+	}
+
+	Font struct {
+		Name      string
+		Fallbacks []string
+		Color     string
+		Uppercase bool
+
+		Bold        string
+		Italic      string
+		BoldItalic  string
+		AccentColor string
+	}
+
+	OutputFormat string
+
+	// Language of the message for hyphenation, might be empty.
+	Language string
+
+	// Fields lists the template's custom fields.
+	Fields []templateFieldInfo
+
+	// TextBoxes lists the template's text boxes with the fields they show.
+	TextBoxes []templateTextBoxInfo
+
+	// ImageSlots lists the template's image slots next to the picture.
+	ImageSlots []templateImageSlotInfo
+
+	// Layouts lists the template's layouts, starting with the default one.
+	Layouts []templateLayoutInfo
+
+	// Placeholders used in the SVG template, e.g., ".Message".
+	Placeholders []string
+}
+
+// templateFieldInfo describes a custom field within a templateInfo.
+type templateFieldInfo struct {
+	Name      string
+	Type      string
+	MaxLength int
+	Default   string
+	Required  bool
+}
+
+// templateTextBoxInfo describes a text box within a templateInfo.
+type templateTextBoxInfo struct {
+	Name  string
+	Field string
+}
+
+// templateImageSlotInfo describes an image slot within a templateInfo.
+type templateImageSlotInfo struct {
+	Name string
+
+	Width    int
+	Height   int
+	Optional bool
+}
+
+// templateLayoutInfo describes a layout within a templateInfo.
+type templateLayoutInfo struct {
+	Name string
+
+	Width  int
+	Height int
+}
+
+// templatesHandler lists all loaded templates as a JSON array, allowing
+// clients to build their forms dynamically.
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+	if method := r.Method; method != "GET" {
+		http.Error(w, "HTTP GET only", http.StatusMethodNotAllowed)
+		return
+	}
+
+	templates := loadedTemplates()
+
+	infos := make([]templateInfo, 0, len(templates.sharepicConfs))
+	for _, name := range templates.names() {
+		conf := templates.sharepicConfs[name]
+
+		info := templateInfo{
+			Name:            name,
+			Width:           conf.Sharepic.Width,
+			Height:          conf.Sharepic.Height,
+			MessageDisabled: conf.MessageBox.Disable,
+			PictureDisabled: conf.PictureBox.Disable,
+			PictureOptional: conf.PictureBox.Optional,
+			Placeholders:    templates.placeholders(name),
+		}
+		info.MaxLength.Message = conf.MaxLength.Message
+		info.MaxLength.Author = conf.MaxLength.Author
+		info.MaxLength.Description = conf.MaxLength.Description
+		info.Font.Name = conf.Font.Name
+		info.Font.Fallbacks = conf.Font.Fallbacks
+		info.Font.Color = conf.Font.Color
+		info.Font.Uppercase = conf.Font.Uppercase
+		info.Font.Bold = conf.Font.Bold
+		info.Font.Italic = conf.Font.Italic
+		info.Font.BoldItalic = conf.Font.BoldItalic
+		info.Font.AccentColor = conf.Font.AccentColor
+
+		info.OutputFormat = conf.Output.Format
+		if info.OutputFormat == "" {
+			info.OutputFormat = defaultOutputFormat
+		}
+		info.Language = conf.Language
+
+		for _, name := range conf.fieldNames() {
+			field := conf.Fields[name]
+			fieldType := field.Type
+			if fieldType == "" {
+				fieldType = fieldTypeString
+			}
+			info.Fields = append(info.Fields, templateFieldInfo{
+				Name:      name,
+				Type:      fieldType,
+				MaxLength: field.MaxLength,
+				Default:   field.Default,
+				Required:  field.Required,
+			})
+		}
+
+		for _, textBox := range conf.textBoxNames() {
+			info.TextBoxes = append(info.TextBoxes, templateTextBoxInfo{
+				Name:  textBox,
+				Field: conf.TextBoxes[textBox].Field,
+			})
+		}
+
+		for _, slot := range conf.slotNames() {
+			info.ImageSlots = append(info.ImageSlots, templateImageSlotInfo{
+				Name:     slot,
+				Width:    conf.ImageSlots[slot].Width,
+				Height:   conf.ImageSlots[slot].Height,
+				Optional: conf.ImageSlots[slot].Optional,
+			})
+		}
+
+		for _, layout := range conf.layoutNames() {
+			layoutConf, _, _ := conf.withLayout(name, layout)
+			info.Layouts = append(info.Layouts, templateLayoutInfo{
+				Name:   layout,
+				Width:  layoutConf.Sharepic.Width,
+				Height: layoutConf.Sharepic.Height,
+			})
+		}
+
+		infos = append(infos, info)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(true)
+	if err := encoder.Encode(infos); err != nil {
+		log.Printf("cannot encode templates to JSON, %v", err)
+	}
 }
 
 // healthHandler will be queried by Docker to check if the service is still up.
@@ -200,6 +640,8 @@ func healthHandler(w http.ResponseWriter, _ *http.Request) {
 func LaunchWebserver() {
 	http.HandleFunc("/health", healthHandler)
 	http.HandleFunc("/sharepic", sharepicHandler)
+	http.HandleFunc("/templates", templatesHandler)
+	http.HandleFunc("/batch", batchHandler)
 
 	server := &http.Server{
 		Addr:              ":8080",
@@ -0,0 +1,44 @@
+// SPDX-FileCopyrightText: Free Software Foundation Europe <https://fsfe.org>
+//
+// SPDX-License-Identifier: AGPL-3.0-or-later
+
+// This piece of JavaScript adjusts the picture upload to the selected template
+// based on the backend's templates catalogue. The upload is hidden for
+// templates without a picture and not required for an optional picture.
+
+const pictureLabel = document.querySelector('label[for=file]');
+const pictureInput = document.getElementById('img');
+
+// pictureInfos maps template names to their catalogue entries.
+const pictureInfos = {};
+
+// setPictureField adjusts the picture upload for the given template, keeping
+// it required for templates unknown to the catalogue.
+const setPictureField = template => {
+  const info = pictureInfos[template];
+  const disabled = info !== undefined && info.PictureDisabled;
+  const optional = info !== undefined && info.PictureOptional;
+
+  pictureLabel.hidden = disabled;
+  pictureInput.required = !disabled && !optional;
+};
+
+fetch('/templates')
+.then(resp => resp.json())
+.then(infos => {
+  infos.forEach(info => {
+    pictureInfos[info.Name] = info;
+  });
+
+  const selected = document.querySelector('input[name=template]:checked');
+  if (selected) {
+    setPictureField(selected.value);
+  }
+})
+.catch(error => {
+  console.error('cannot load templates', error);
+});
+
+document.querySelectorAll('input[name=template]').forEach(input => {
+  input.addEventListener('change', () => setPictureField(input.value));
+});
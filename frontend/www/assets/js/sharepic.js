@@ -24,19 +24,32 @@ const switchToModal = () => {
   sharepicError.style.display = 'none';
 };
 
-// Switch to the modal with a successfully generated sharepic.
-const showSharepic = jpeg => {
-  switchToModal();
+// fileExtensions maps the MIME types of the sharepic's formats to extensions.
+const fileExtensions = {
+  'application/pdf': 'pdf',
+  'image/avif': 'avif',
+  'image/jpeg': 'jpg',
+  'image/png': 'png',
+  'image/webp': 'webp'
+};
 
-  const imgUrl = 'data:image/jpeg;base64,' + jpeg;
+// Switch to the modal with a successfully generated sharepic. Formats other
+// than images, e.g., PDF, cannot be previewed and are only offered for download.
+const showSharepic = (mimeType, image) => {
+  switchToModal();
 
-  sharepicImg.setAttribute('src', imgUrl);
+  const imgUrl = 'data:' + mimeType + ';base64,' + image;
 
   sharepicDownloadBtn.href = imgUrl;
-  sharepicDownloadBtn.download = "sharepic.jpg";
-
-  sharepicImg.style.display = '';
+  sharepicDownloadBtn.download = 'sharepic.' + (fileExtensions[mimeType] || 'jpg');
   sharepicDownloadBtn.style.display = '';
+
+  if (mimeType.startsWith('image/')) {
+    sharepicImg.setAttribute('src', imgUrl);
+    sharepicImg.style.display = '';
+  } else {
+    sharepicImg.removeAttribute('src');
+  }
 };
 
 // Switch to the modal with an error message.
@@ -61,7 +74,7 @@ const composeSharepic = () => {
   .then(resp => resp.json())
   .then(result => {
     if (result.Error == '') {
-      showSharepic(result.Jpeg);
+      showSharepic(result.MimeType, result.Image);
     } else {
       showError(result.Error);
     }